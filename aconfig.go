@@ -1,13 +1,26 @@
 package aconfig
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -18,18 +31,70 @@ import (
 
 const (
 	defaultValueTag = "default"
+	defaultIfTag    = "default_if"
 	envNameTag      = "env"
 	flagNameTag     = "flag"
+	envAliasesTag   = "env_aliases"
+	flagAliasesTag  = "flag_aliases"
+	kvNameTag       = "kv"
+	implTag         = "impl"
+	deprecatedTag   = "deprecated"
+	transformTag    = "transform"
 	usageTag        = "usage"
+	envFormatTag    = "env_format"
+	mergeTag        = "merge"
+	allowInfTag     = "allow_inf"
+	byteSizeTag     = "bytesize"
+	sourceTag       = "source"
+	envCollectTag   = "env_prefix_collect"
+	timezoneTag     = "timezone"
+	inlineJSONTag   = "inline_json"
+	inlineYAMLTag   = "inline_yaml"
+	envKVTag        = "env_kv"
+	percentTag      = "percent"
+	aconfigTag      = "aconfig"
+
+	// stdinFile is a file name to read configuration from stdin.
+	stdinFile = "-"
 )
 
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// supportedFormats of configuration files.
+var supportedFormats = []string{".json", ".yaml", ".yml", ".toml"}
+
+// timeLayouts are tried in order to parse time.Time values.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999 -0700 MST", // time.Time.String()
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// templateRegexp matches references to other fields in default values: ${Name}.
+var templateRegexp = regexp.MustCompile(`\$\{([^}]+)\}`)
+
 // Loader of user configuration.
 type Loader struct {
 	config  loaderConfig
 	src     interface{}
+	dst     interface{}
 	fields  []*fieldData
+	lazy    []*fieldData
+	impls   []*fieldData
 	flagSet *flag.FlagSet
 	isBuilt bool
+
+	layouts    map[layoutKey]*fieldLayout
+	structPtrs []*fieldData
+	walking    map[reflect.Type]bool
+	fileErrors []error
+	input      *configInput
+	prefix     string
+	stdin      []byte
 }
 
 // loaderConfig to configure configuration loader.
@@ -38,13 +103,55 @@ type loaderConfig struct {
 	SkipFile     bool
 	SkipEnv      bool
 	SkipFlag     bool
+	SkipKV       bool
+
+	SkipValidation bool
 
 	EnvPrefix  string
 	FlagPrefix string
+	Profile    string
+
+	EnvFallbackPrefixes []string
+	Env                 map[string]string
+
+	SplitEnvWords      bool
+	EmptyEnvClears     bool
+	FlagWordsSeparator string
+	NegatedFlags       bool
+	NestingSeparator   string
+
+	DefaultValueTag    string
+	DefaultsAsFallback bool
+	DefaultsFile       string
 
 	FailOnNotParsedFlags  bool
+	FailOnUnknownEnv      bool
+	FailOnUnknownFileKeys bool
+	AllowedEnv            []string
 	ShouldStopOnFileError bool
+	ContinueOnFileError   bool
+	UseFileTags           bool
+	CaseInsensitiveKeys   bool
+	IgnoreCase            bool
+	IncludeZeroDefaults   bool
+	AllowURLs             bool
+	DefaultFormat         string
+	StdinFormat           string
+	ClearToken            string
 	Files                 []string
+	FileDirs              []string
+	FileBaseName          string
+
+	KVSource KVSource
+	KVPrefix string
+
+	Literals         map[string]string
+	LiteralsOverride bool
+
+	Impls map[string]func() interface{}
+
+	Logger     func(format string, args ...interface{})
+	OnFieldSet func(fieldName, source, rawValue string)
 }
 
 // Field of the user configuration structure.
@@ -77,6 +184,21 @@ func (l *Loader) SkipDefaults() *Loader {
 	return l
 }
 
+// DefaultsAsFallback to set defaults after all other sources and only to fields
+// that remain unset, by default they're set first and other sources override them.
+func (l *Loader) DefaultsAsFallback() *Loader {
+	l.config.DefaultsAsFallback = true
+	return l
+}
+
+// WithDefaultsFile to load defaults from a file, like `defaults.yaml`,
+// its values override tag defaults and are overridden by all other sources.
+// The file is skipped with SkipDefaults, not with SkipFiles.
+func (l *Loader) WithDefaultsFile(file string) *Loader {
+	l.config.DefaultsFile = file
+	return l
+}
+
 // SkipFiles if you don't want to use them.
 func (l *Loader) SkipFiles() *Loader {
 	l.config.SkipFile = true
@@ -95,12 +217,74 @@ func (l *Loader) SkipFlags() *Loader {
 	return l
 }
 
+// SkipKV if you don't want to use a key-value store.
+func (l *Loader) SkipKV() *Loader {
+	l.config.SkipKV = true
+	return l
+}
+
+// WithSources to load only from the given sources, like `SourceEnv|SourceFlag`,
+// it replaces Skip* options: the sources that aren't given are skipped.
+// SourceLiteral is controlled by WithLiterals only.
+func (l *Loader) WithSources(s Source) *Loader {
+	l.config.setSources(s)
+	return l
+}
+
+// Sources returns the sources which aren't skipped by Skip* options or WithSources.
+func (l *Loader) Sources() Source {
+	return l.config.sources()
+}
+
+// WithEnv sets environment variables to use instead of the process environment, handy for tests.
+func (l *Loader) WithEnv(env map[string]string) *Loader {
+	l.config.Env = env
+	return l
+}
+
+// EmptyEnvClears to clear the field to its zero value with an empty environment variable like `PORT=`,
+// by default empty variables are skipped like unset ones.
+func (l *Loader) EmptyEnvClears() *Loader {
+	l.config.EmptyEnvClears = true
+	return l
+}
+
+// SkipValidation if you want to run Validate method by yourself.
+func (l *Loader) SkipValidation() *Loader {
+	l.config.SkipValidation = true
+	return l
+}
+
 // WithFiles for a configuration.
 func (l *Loader) WithFiles(files []string) *Loader {
 	l.config.Files = files
 	return l
 }
 
+// WithLiterals sets values of the fields by their names like `HTTP.Port`,
+// values are parsed like env variables. By default they're applied before the files
+// as a fallback for other sources, see LiteralsOverride.
+func (l *Loader) WithLiterals(values map[string]string) *Loader {
+	l.config.Literals = values
+	return l
+}
+
+// LiteralsOverride to apply values from WithLiterals after flags, so they override all other sources.
+func (l *Loader) LiteralsOverride() *Loader {
+	l.config.LiteralsOverride = true
+	return l
+}
+
+// WithFileDiscovery to find a configuration file by its name without extension, like `config`.
+// Files like `config.json`, `config.yaml`, `config.yml` and `config.toml` are looked up in the dirs
+// (current directory by default) in that order and used after the files from WithFiles.
+// The first decoded file is loaded, it's not an error if nothing is found.
+func (l *Loader) WithFileDiscovery(baseName string, dirs ...string) *Loader {
+	l.config.FileBaseName = baseName
+	l.config.FileDirs = dirs
+	return l
+}
+
 // WithEnvPrefix to specify environment prefix.
 func (l *Loader) WithEnvPrefix(prefix string) *Loader {
 	l.config.EnvPrefix = prefix
@@ -110,6 +294,65 @@ func (l *Loader) WithEnvPrefix(prefix string) *Loader {
 	return l
 }
 
+// WithNestingSeparator to join names of nested fields in env and flag names,
+// by default `HTTP.Port` gives `HTTP_PORT` env and `http.port` flag, with `__` it gives `HTTP__PORT` and `http__port`.
+func (l *Loader) WithNestingSeparator(sep string) *Loader {
+	l.config.NestingSeparator = sep
+	return l
+}
+
+// WithEnvPrefixes to specify environment prefixes tried in order for each field,
+// so `MYAPP_PORT` wins, but `OLDAPP_PORT` is used if the first one isn't set.
+// The first prefix is used like the one set by WithEnvPrefix.
+func (l *Loader) WithEnvPrefixes(prefixes ...string) *Loader {
+	l.config.EnvPrefix = ""
+	l.config.EnvFallbackPrefixes = nil
+	for i, prefix := range prefixes {
+		if prefix != "" {
+			prefix += "_"
+		}
+		if i == 0 {
+			l.config.EnvPrefix = prefix
+		} else {
+			l.config.EnvFallbackPrefixes = append(l.config.EnvFallbackPrefixes, prefix)
+		}
+	}
+	return l
+}
+
+// SplitEnvWords to separate words of field names in env names: `HTTPPort` gives `HTTP_PORT`.
+func (l *Loader) SplitEnvWords() *Loader {
+	l.config.SplitEnvWords = true
+	return l
+}
+
+// SplitFlagWords to separate words of field names in flag names with a given separator:
+// `HTTPPort` gives `http-port` for "-" (without this option it's `httpport`).
+func (l *Loader) SplitFlagWords(sep string) *Loader {
+	l.config.FlagWordsSeparator = sep
+	return l
+}
+
+// WithNegatedFlags to define `-no-<name>` flag for each bool field, it sets the field to false.
+// Passing both `-name` and `-no-name` is an error.
+func (l *Loader) WithNegatedFlags() *Loader {
+	l.config.NegatedFlags = true
+	return l
+}
+
+// WithProfile to use profile specific defaults.
+// Tag `default_<profile>` is used when present, otherwise the `default` tag.
+func (l *Loader) WithProfile(profile string) *Loader {
+	l.config.Profile = profile
+	return l
+}
+
+// WithDefaultTag to read defaults from a given tag instead of `default`.
+func (l *Loader) WithDefaultTag(tag string) *Loader {
+	l.config.DefaultValueTag = tag
+	return l
+}
+
 // FailOnNotParsedFlags to not forget parse flags explicitly.
 // Use `l.FlagSet().Parse(os.Args[1:])` in your code for this.
 //
@@ -118,12 +361,118 @@ func (l *Loader) FailOnNotParsedFlags() *Loader {
 	return l
 }
 
+// AllowURLs to load files listed as `http://` or `https://` URLs.
+// Format is taken from the URL path or from the Content-Type header.
+func (l *Loader) AllowURLs() *Loader {
+	l.config.AllowURLs = true
+	return l
+}
+
+// ContinueOnFileError to continue with the next file when a file cannot be read or parsed.
+// Errors are not returned by Load but collected, see FileErrors.
+// Missing files are skipped without an error. StopOnFileError takes precedence.
+func (l *Loader) ContinueOnFileError() *Loader {
+	l.config.ContinueOnFileError = true
+	return l
+}
+
+// FileErrors returns file errors skipped during the last load, see ContinueOnFileError.
+func (l *Loader) FileErrors() []error {
+	return l.fileErrors
+}
+
+// WithDefaultFormat to parse files without extension, like "yaml" or "json".
+func (l *Loader) WithDefaultFormat(format string) *Loader {
+	l.config.DefaultFormat = formatExt(format)
+	return l
+}
+
+// WithStdinFormat to parse stdin, which is read for `-` file, like "yaml" or "json".
+// The default format is used if it's not set, see WithDefaultFormat.
+func (l *Loader) WithStdinFormat(format string) *Loader {
+	l.config.StdinFormat = formatExt(format)
+	return l
+}
+
+// FailOnUnknownEnv to fail when an environment variable with the env prefix
+// doesn't match any field. The error suggests the closest known variable.
+// Works only with a non-empty env prefix, see WithEnvPrefix and WithAllowedEnv.
+func (l *Loader) FailOnUnknownEnv() *Loader {
+	l.config.FailOnUnknownEnv = true
+	return l
+}
+
+// FailOnUnknownFileKeys to fail when a file has a key that doesn't match any field.
+// Keys are matched by the tag of the file format (`json`, `yaml` or `toml`) or by the Go name
+// the way the decoder does it: YAML keys must match exactly, JSON and TOML keys in any case,
+// any key is accepted in maps and in structs with `aconfig:",remain"` field.
+func (l *Loader) FailOnUnknownFileKeys() *Loader {
+	l.config.FailOnUnknownFileKeys = true
+	return l
+}
+
+// WithAllowedEnv to not fail on the given prefixed variables that aren't config fields.
+func (l *Loader) WithAllowedEnv(names []string) *Loader {
+	l.config.AllowedEnv = names
+	return l
+}
+
 // StopOnFileError to stop configuration loading on file error.
 func (l *Loader) StopOnFileError() *Loader {
 	l.config.ShouldStopOnFileError = true
 	return l
 }
 
+// UseFileTags to name fields after their tag for the format of the first file.
+// Env and flag names are computed from this name too,
+// so `yaml:"max_conns"` gives `MAX_CONNS` and `max_conns` instead of `MAXCONNS`.
+func (l *Loader) UseFileTags() *Loader {
+	l.config.UseFileTags = true
+	return l
+}
+
+// OnFieldSet sets a hook called every time a field is set by a source (`default`,
+// `file`, `env`, `flag`, `kv` or `literal`) with the raw value from the source.
+func (l *Loader) OnFieldSet(fn func(fieldName, source, rawValue string)) *Loader {
+	l.config.OnFieldSet = fn
+	return l
+}
+
+// WithLogger sets a logger for warnings, like usage of deprecated fields, log.Printf is used by default.
+func (l *Loader) WithLogger(logger func(format string, args ...interface{})) *Loader {
+	l.config.Logger = logger
+	return l
+}
+
+// CaseInsensitiveKeys to match file keys ignoring their case, dotted keys like `http.port` are matched too.
+// Files are decoded into a map first and then values are set to the fields one by one.
+func (l *Loader) CaseInsensitiveKeys() *Loader {
+	l.config.CaseInsensitiveKeys = true
+	return l
+}
+
+// IgnoreCase to match string map keys and `oneof` values ignoring their case.
+// Loaded values are set to a canonical form: map keys are lower cased
+// and `oneof` values are spelled as in the tag.
+func (l *Loader) IgnoreCase() *Loader {
+	l.config.IgnoreCase = true
+	return l
+}
+
+// WithClearToken sets a file value which clears the field, for example "~unset".
+// Cleared fields are set to zero values (nil for pointers) overriding defaults.
+// Null clears only pointers, slices, maps and interfaces, the token clears fields of any type.
+func (l *Loader) WithClearToken(token string) *Loader {
+	l.config.ClearToken = token
+	return l
+}
+
+// IncludeZeroDefaults to not omit fields with empty or zero defaults in generated output.
+func (l *Loader) IncludeZeroDefaults() *Loader {
+	l.config.IncludeZeroDefaults = true
+	return l
+}
+
 // WithFlagPrefix to specify command-line flags prefix.
 func (l *Loader) WithFlagPrefix(prefix string) *Loader {
 	l.config.FlagPrefix = prefix
@@ -142,15 +491,59 @@ func (l *Loader) Build() *Loader {
 
 func (l *Loader) parseFields(cfg interface{}) {
 	l.flagSet = flag.NewFlagSet(l.config.FlagPrefix, flag.ContinueOnError)
-	l.fields = getFields(cfg)
+	l.fields = l.getFields(cfg)
+	l.releaseLazy()
 
 	if l.config.SkipFlag {
 		return
 	}
 	for _, field := range l.fields {
+		// flags aren't defined for the fields which cannot be set by them, see `source` tag
+		if !field.allows(SourceFlag) {
+			continue
+		}
 		flagName := l.getFlagName(field)
-		l.flagSet.String(flagName, field.defaultValue, field.usage)
+		l.defineFlag(field, flagName, field.usage)
+
+		for _, alias := range l.getFlagAliases(field) {
+			l.defineFlag(field, alias, fmt.Sprintf("alias of -%s", flagName))
+		}
+
+		if l.config.NegatedFlags && isBoolField(field) {
+			if name := l.negatedFlagName(flagName); l.flagSet.Lookup(name) == nil {
+				l.flagSet.Bool(name, false, fmt.Sprintf("negation of -%s", flagName))
+			}
+		}
+	}
+}
+
+// negatedFlagName returns a name of the flag that sets bool field to false, see WithNegatedFlags.
+// The flag prefix is kept: `-app.no-debug` for `-app.debug`.
+func (l *Loader) negatedFlagName(name string) string {
+	prefix := strings.ToLower(l.config.FlagPrefix)
+	return prefix + "no-" + strings.TrimPrefix(name, prefix)
+}
+
+// defineFlag defines a flag for the field, bool fields can be set just by `-name`.
+// Duplicate names aren't defined, they're reported by Load, see checkDuplicateNames.
+func (l *Loader) defineFlag(field *fieldData, name, usage string) {
+	if l.flagSet.Lookup(name) != nil {
+		return
 	}
+	if isBoolField(field) {
+		value, _ := strconv.ParseBool(field.defaultValue)
+		l.flagSet.Bool(name, value, usage)
+		return
+	}
+	l.flagSet.String(name, field.defaultValue, usage)
+}
+
+func isBoolField(field *fieldData) bool {
+	typ := field.field.Type
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ.Kind() == reflect.Bool
 }
 
 // Flags returngs flag.FlagSet to create your own flags.
@@ -159,8 +552,22 @@ func (l *Loader) Flags() *flag.FlagSet {
 	return l.flagSet
 }
 
+// Fields returns configuration fields in the order of WalkFields.
+func (l *Loader) Fields() []Field {
+	l.assertBuilt()
+	fields := make([]Field, 0, len(l.fields))
+	for _, f := range l.fields {
+		fields = append(fields, f)
+	}
+	return fields
+}
+
 // WalkFields iterates over configuration fields.
 // Easy way to create documentation or other stuff.
+//
+// Fields are visited in struct declaration order (depth-first):
+// fields of a nested struct go right after each other at the position of the struct,
+// fields of an embedded struct are placed at the position of the embedding.
 func (l *Loader) WalkFields(fn func(f Field) bool) {
 	l.assertBuilt()
 	for _, f := range l.fields {
@@ -172,101 +579,1525 @@ func (l *Loader) WalkFields(fn func(f Field) bool) {
 
 // Load configuration into a given param.
 func (l *Loader) Load(into interface{}) error {
+	return l.LoadContext(context.Background(), into)
+}
+
+// LoadContext configuration into a given param.
+// Loading is stopped when the context is done, the context is checked between the sources.
+func (l *Loader) LoadContext(ctx context.Context, into interface{}) (err error) {
 	l.assertBuilt()
+	defer l.recoverLoad(&err)
+
+	if err := l.checkTarget(into); err != nil {
+		return err
+	}
 	// we need to get fields once more, 'cause `into` is new for us
-	l.fields = getFields(into)
+	l.fields = l.scopeFields(l.getFields(into))
+	if err := l.loadFields(ctx, into); err != nil {
+		// nil pointers of the target aren't left pointing to partly loaded structs
+		l.releaseLazy()
+		return err
+	}
+	l.releaseLazy()
+	l.warnDeprecated()
+	if l.config.SkipValidation {
+		return nil
+	}
+	return l.Validate()
+}
+
+// loadFields loads the fields of the target from the sources.
+func (l *Loader) loadFields(ctx context.Context, into interface{}) error {
+	if err := l.checkDuplicateNames(); err != nil {
+		return fmt.Errorf("aconfig: %w", err)
+	}
+	if err := l.checkSourceTags(); err != nil {
+		return fmt.Errorf("aconfig: %w", err)
+	}
+	if err := l.checkEnvKVTags(); err != nil {
+		return fmt.Errorf("aconfig: %w", err)
+	}
+	l.dst = into
+	l.fileErrors = nil
+	l.resetMerged()
+
+	if err := l.loadWithImpls(ctx, into); err != nil {
+		return fmt.Errorf("aconfig: cannot load config: %w", err)
+	}
+	return nil
+}
+
+// resetMerged clears slices and maps with `merge:"true"` tag before a load,
+// so each source is merged with the previous one and not with the result of the last load.
+func (l *Loader) resetMerged() {
+	for _, fd := range l.fields {
+		if !fd.merge {
+			continue
+		}
+		switch fd.value.Kind() {
+		case reflect.Slice, reflect.Map:
+			fd.value.Set(reflect.Zero(fd.value.Type()))
+		}
+	}
+}
+
+// LoadWithFile configuration into a given param.
+func (l *Loader) LoadWithFile(into interface{}, file string) error {
+	l.config.Files = []string{file}
+
+	return l.Load(into)
+}
+
+// configInput is an in-memory config used instead of the files.
+type configInput struct {
+	data []byte
+	ext  string
+}
+
+// LoadReader configuration into a given param, content of the reader is used instead of the files.
+// Format is one of the supported file formats, like "json" or ".yaml".
+func (l *Loader) LoadReader(into interface{}, r io.Reader, format string) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("aconfig: cannot read config: %w", err)
+	}
+	return l.LoadBytes(into, data, format)
+}
+
+// LoadBytes configuration into a given param, data is used instead of the files.
+// Format is one of the supported file formats, like "json" or ".yaml".
+func (l *Loader) LoadBytes(into interface{}, data []byte, format string) error {
+	l.input = &configInput{data: data, ext: formatExt(format)}
+	defer func() { l.input = nil }()
+
+	return l.Load(into)
+}
+
+// LoadOption overrides loader configuration for a single LoadWith call.
+type LoadOption func(*loaderConfig)
+
+// WithoutDefaults to skip defaults for a single load.
+func WithoutDefaults() LoadOption {
+	return func(c *loaderConfig) { c.SkipDefaults = true }
+}
+
+// WithoutFiles to skip files for a single load.
+func WithoutFiles() LoadOption {
+	return func(c *loaderConfig) { c.SkipFile = true }
+}
+
+// WithoutEnv to skip environment for a single load.
+func WithoutEnv() LoadOption {
+	return func(c *loaderConfig) { c.SkipEnv = true }
+}
+
+// WithoutFlags to skip command-line flags for a single load.
+func WithoutFlags() LoadOption {
+	return func(c *loaderConfig) { c.SkipFlag = true }
+}
+
+// WithoutKV to skip key-value store for a single load.
+func WithoutKV() LoadOption {
+	return func(c *loaderConfig) { c.SkipKV = true }
+}
+
+// OnlyDefaults to load only defaults for a single load.
+func OnlyDefaults() LoadOption {
+	return func(c *loaderConfig) {
+		c.setSources(SourceDefault)
+	}
+}
+
+// OnlyFile to load only files for a single load.
+func OnlyFile() LoadOption {
+	return func(c *loaderConfig) {
+		c.setSources(SourceFile)
+	}
+}
+
+// OnlyEnv to load only environment for a single load.
+func OnlyEnv() LoadOption {
+	return func(c *loaderConfig) {
+		c.setSources(SourceEnv)
+	}
+}
+
+// OnlyFlags to load only command-line flags for a single load.
+func OnlyFlags() LoadOption {
+	return func(c *loaderConfig) {
+		c.setSources(SourceFlag)
+	}
+}
+
+// OnlySources to load only from the given sources for a single load, see WithSources.
+func OnlySources(s Source) LoadOption {
+	return func(c *loaderConfig) { c.setSources(s) }
+}
+
+// LoadWith configuration into a given param with options applied only for this call.
+// Base configuration of the loader isn't changed.
+func (l *Loader) LoadWith(into interface{}, opts ...LoadOption) error {
+	base := l.config
+	defer func() { l.config = base }()
+
+	for _, opt := range opts {
+		opt(&l.config)
+	}
+	return l.Load(into)
+}
+
+func (l *Loader) loadSources(ctx context.Context, into interface{}) error {
+	skip := func(s Source) bool { return l.config.sources()&s == 0 }
+	stages := []struct {
+		skip bool
+		load func() error
+	}{
+		{skip(SourceDefault), l.loadDefaults},
+		{skip(SourceDefault) || l.config.DefaultsFile == "", func() error { return l.loadDefaultsFile(ctx) }},
+		{l.config.Literals == nil || l.config.LiteralsOverride, l.loadLiterals},
+		{skip(SourceFile), func() error { return l.loadFromFile(ctx, into) }},
+		{skip(SourceKV) || l.config.KVSource == nil, func() error { return l.loadKV(ctx) }},
+		{skip(SourceEnv), l.loadEnvironment},
+		{skip(SourceFlag), l.loadFlags},
+		{l.config.Literals == nil || !l.config.LiteralsOverride, l.loadLiterals},
+		{skip(SourceDefault) || !l.config.DefaultsAsFallback, l.loadTagDefaults},
+		{skip(SourceDefault), l.loadDefaultTemplates},
+		{skip(SourceDefault), l.loadConditionalDefaults},
+		{false, l.applyTransforms},
+		{!l.config.IgnoreCase, l.normalizeCase},
+	}
+
+	for _, stage := range stages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if stage.skip {
+			continue
+		}
+		if err := stage.load(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Defaulter can be implemented by a configuration structure (or a nested one)
+// to set defaults in code, tag defaults and other sources override them.
+type Defaulter interface {
+	SetDefaults()
+}
+
+func (l *Loader) loadDefaults() error {
+	if l.prefix != "" {
+		l.callDefaultersScoped()
+	} else {
+		callDefaulters(reflect.ValueOf(l.dst))
+	}
+
+	// tag defaults are set after other sources, see DefaultsAsFallback
+	if l.config.DefaultsAsFallback {
+		return nil
+	}
+	return l.loadTagDefaults()
+}
+
+func (l *Loader) loadTagDefaults() error {
+	for _, fd := range l.fields {
+		// templates are resolved after all other sources
+		if isTemplate(fd.defaultValue) {
+			continue
+		}
+		// field is set by other source, see DefaultsAsFallback
+		if fd.source != SourceUnset || !fd.allows(SourceDefault) {
+			continue
+		}
+		if err := setDefaultValue(fd, fd.defaultValue); err != nil {
+			return err
+		}
+		if fd.defaultValue != "" {
+			l.setSource(fd, SourceDefault, fd.defaultValue)
+		}
+	}
+	return nil
+}
+
+// callDefaulters calls SetDefaults of the struct and then of the nested ones.
+func callDefaulters(value reflect.Value) {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct || !value.CanAddr() {
+		return
+	}
+
+	if d, ok := value.Addr().Interface().(Defaulter); ok {
+		d.SetDefaults()
+	}
+	for i := 0; i < value.NumField(); i++ {
+		if field := value.Field(i); field.CanSet() {
+			callDefaulters(field)
+		}
+	}
+}
+
+// setDefaultValue sets a default value to the field.
+// Defaults of slices, maps and structs can be written as JSON: `default:"[1,2,3]"`,
+// otherwise the comma (and colon for maps) syntax is used.
+func setDefaultValue(fd *fieldData, value string) error {
+	if !isJSONDefault(fd, value) {
+		return setFieldDataHelper(fd, value)
+	}
+	if err := json.Unmarshal([]byte(value), fd.value.Addr().Interface()); err != nil {
+		return fmt.Errorf("incorrect JSON default of field %q: %w", fd.name, err)
+	}
+	return nil
+}
+
+func isJSONDefault(fd *fieldData, value string) bool {
+	// inline fields are decoded in their own format, see setInline
+	if inlineFormat(fd.field) != "" {
+		return false
+	}
+	typ := fd.value.Type()
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	value = strings.TrimSpace(value)
+	switch typ.Kind() {
+	case reflect.Slice:
+		return strings.HasPrefix(value, "[")
+	case reflect.Map, reflect.Struct:
+		return strings.HasPrefix(value, "{")
+	default:
+		return false
+	}
+}
+
+// loadDefaultTemplates expands ${Name} references in default values
+// for the fields that weren't set by any other source.
+func (l *Loader) loadDefaultTemplates() error {
+	fields := make(map[string]*fieldData, len(l.fields))
+	for _, fd := range l.fields {
+		fields[fd.name] = fd
+	}
+
+	resolved := map[string]bool{}
+	visiting := map[string]bool{}
+
+	var resolve func(fd *fieldData) error
+	resolve = func(fd *fieldData) error {
+		if resolved[fd.name] || !isTemplate(fd.defaultValue) {
+			return nil
+		}
+		if visiting[fd.name] {
+			return fmt.Errorf("cyclic reference in default value of field %q", fd.name)
+		}
+		visiting[fd.name] = true
+		defer delete(visiting, fd.name)
+
+		// value was set by other source (even to a zero value), don't override it
+		if fd.source != SourceUnset || !fd.allows(SourceDefault) {
+			resolved[fd.name] = true
+			return nil
+		}
+
+		var err error
+		value := templateRegexp.ReplaceAllStringFunc(fd.defaultValue, func(ref string) string {
+			name := templateRegexp.FindStringSubmatch(ref)[1]
+			refField, ok := fields[name]
+			switch {
+			case err != nil:
+				return ""
+			case !ok:
+				err = fmt.Errorf("unknown field %q in default value of field %q", name, fd.name)
+				return ""
+			}
+			if err = resolve(refField); err != nil {
+				return ""
+			}
+			return valueString(refField.value)
+		})
+		if err != nil {
+			return err
+		}
+
+		resolved[fd.name] = true
+		if err := l.setFieldData(fd, value); err != nil {
+			return err
+		}
+		l.setSource(fd, SourceDefault, value)
+		return nil
+	}
+
+	for _, fd := range l.fields {
+		if err := resolve(fd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadConditionalDefaults sets defaults from `default_if:"Field=value:default"` tags
+// for the fields that weren't set by any other source.
+func (l *Loader) loadConditionalDefaults() error {
+	fields := make(map[string]*fieldData, len(l.fields))
+	for _, fd := range l.fields {
+		fields[fd.name] = fd
+	}
+
+	resolved := map[string]bool{}
+	visiting := map[string]bool{}
+
+	var resolve func(fd *fieldData) error
+	resolve = func(fd *fieldData) error {
+		if resolved[fd.name] || fd.defaultIf == "" {
+			return nil
+		}
+		if visiting[fd.name] {
+			return fmt.Errorf("cyclic reference in %s of field %q", defaultIfTag, fd.name)
+		}
+		visiting[fd.name] = true
+		defer delete(visiting, fd.name)
+
+		name, want, value, err := parseDefaultIf(fd.defaultIf)
+		if err != nil {
+			return fmt.Errorf("incorrect %s of field %q: %w", defaultIfTag, fd.name, err)
+		}
+		refField, ok := fields[name]
+		if !ok {
+			return fmt.Errorf("unknown field %q in %s of field %q", name, defaultIfTag, fd.name)
+		}
+		if err := resolve(refField); err != nil {
+			return err
+		}
+		resolved[fd.name] = true
+
+		// value was set by other source (even to a zero value) or condition isn't met
+		if fd.source != SourceUnset || !fd.allows(SourceDefault) || valueString(refField.value) != want {
+			return nil
+		}
+		if err := setDefaultValue(fd, value); err != nil {
+			return err
+		}
+		l.setSource(fd, SourceDefault, value)
+		return nil
+	}
+
+	for _, fd := range l.fields {
+		if err := resolve(fd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseDefaultIf parses `Field=value:default` into its parts.
+func parseDefaultIf(tag string) (name, want, value string, err error) {
+	cond := strings.SplitN(tag, "=", 2)
+	if len(cond) != 2 || cond[0] == "" {
+		return "", "", "", fmt.Errorf("want Field=value:default, got %q", tag)
+	}
+	rest := strings.SplitN(cond[1], ":", 2)
+	if len(rest) != 2 {
+		return "", "", "", fmt.Errorf("want Field=value:default, got %q", tag)
+	}
+	return strings.TrimSpace(cond[0]), rest[0], rest[1], nil
+}
+
+func isTemplate(value string) bool {
+	return templateRegexp.MatchString(value)
+}
+
+func valueString(value reflect.Value) string {
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return ""
+		}
+		value = value.Elem()
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+func (l *Loader) loadFromFile(ctx context.Context, dst interface{}) error {
+	if l.input != nil {
+		return l.loadFromInput(dst)
+	}
+
+	for _, file := range l.configFiles() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		data, ext, err := l.readFile(ctx, file)
+		if err != nil {
+			if l.config.ShouldStopOnFileError {
+				return err
+			}
+			if l.config.ContinueOnFileError && !os.IsNotExist(err) {
+				l.fileErrors = append(l.fileErrors, fmt.Errorf("cannot read file %q: %w", file, err))
+			}
+			continue
+		}
+
+		if !isSupportedFormat(ext) {
+			err := unsupportedFormatError(file, ext)
+			if l.config.ShouldStopOnFileError || !l.config.ContinueOnFileError {
+				return err
+			}
+			l.fileErrors = append(l.fileErrors, err)
+			continue
+		}
+
+		err = l.decodeFile(data, ext, dst)
+		if err == nil {
+			return nil
+		}
+		if l.config.ShouldStopOnFileError {
+			return fmt.Errorf("file parsing error: %w", err)
+		}
+		if l.config.ContinueOnFileError {
+			l.fileErrors = append(l.fileErrors, fmt.Errorf("cannot parse file %q: %w", file, err))
+		}
+	}
+	return nil
+}
+
+func (l *Loader) loadFromInput(dst interface{}) error {
+	ext := l.input.ext
+	if ext == "" {
+		ext = l.config.DefaultFormat
+	}
+	if !isSupportedFormat(ext) {
+		return unsupportedFormatError("", ext)
+	}
+	if err := l.decodeFile(l.input.data, ext, dst); err != nil {
+		return fmt.Errorf("config parsing error: %w", err)
+	}
+	return nil
+}
+
+// readFile returns content of the file (or URL, or stdin for `-`) with its format.
+func (l *Loader) readFile(ctx context.Context, file string) ([]byte, string, error) {
+	var data []byte
+	var ext string
+	var err error
+	switch {
+	case file == stdinFile:
+		data, ext, err = l.readStdin()
+	case l.config.AllowURLs && isURL(file):
+		data, ext, err = fetchURL(ctx, file)
+	default:
+		data, err = ioutil.ReadFile(file)
+		ext = fileExt(file)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if ext == "" {
+		ext = l.config.DefaultFormat
+	}
+
+	if isGzipFile(file) {
+		if data, err = gunzip(data); err != nil {
+			return nil, "", fmt.Errorf("cannot decompress file %q: %w", file, err)
+		}
+	}
+	return data, ext, nil
+}
+
+// readStdin reads stdin once, so it can be used in many loads, stdin isn't closed.
+func (l *Loader) readStdin() ([]byte, string, error) {
+	if l.stdin == nil {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, "", fmt.Errorf("cannot read stdin: %w", err)
+		}
+		l.stdin = data
+	}
+	return l.stdin, l.config.StdinFormat, nil
+}
+
+// formatExt returns a format like "YAML" as an extension like ".yaml".
+func formatExt(format string) string {
+	ext := strings.ToLower(format)
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
+
+func isURL(file string) bool {
+	return strings.HasPrefix(file, "http://") || strings.HasPrefix(file, "https://")
+}
+
+func fetchURL(ctx context.Context, rawURL string) ([]byte, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("cannot fetch %q: unexpected status %q", rawURL, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot fetch %q: %w", rawURL, err)
+	}
+
+	ext := fileExt(u.Path)
+	if ext == "" {
+		ext = contentTypeExt(resp.Header.Get("Content-Type"))
+	}
+	return data, ext, nil
+}
+
+func contentTypeExt(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/json":
+		return ".json"
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return ".yaml"
+	case "application/toml", "text/toml":
+		return ".toml"
+	default:
+		return ""
+	}
+}
+
+// fileExt returns lowercased extension of the file, `.gz` suffix is skipped.
+func fileExt(file string) string {
+	name, ext := fileName(file)
+	if strings.ToLower(ext(name)) == ".gz" {
+		name = name[:len(name)-len(".gz")]
+	}
+	return strings.ToLower(ext(name))
+}
+
+func isGzipFile(file string) bool {
+	name, ext := fileName(file)
+	return strings.ToLower(ext(name)) == ".gz"
+}
+
+// fileName returns the name to get the extension from with a function to do that,
+// for a URL it's the path without the query.
+func fileName(file string) (string, func(string) string) {
+	if isURL(file) {
+		if u, err := url.Parse(file); err == nil {
+			return u.Path, path.Ext
+		}
+	}
+	return file, filepath.Ext
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	return ioutil.ReadAll(r)
+}
+
+func (l *Loader) decodeFile(data []byte, ext string, dst interface{}) error {
+	// an empty file (like a mounted but not yet populated config map) has no values in any format,
+	// JSON decoder fails on it, so it's skipped here
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	if !isStructTarget(dst) {
+		return decodeData(data, ext, dst)
+	}
+	if l.prefix != "" {
+		return l.decodeFileScoped(data, ext)
+	}
+
+	// orig keeps numbers and key types of the file, so it can be encoded back as is,
+	// raw is its copy with string keys to look up the fields
+	orig, err := decodeRawData(data, ext)
+	if err != nil {
+		return err
+	}
+	raw, _ := normalizeRaw(orig).(map[string]interface{})
+	if l.config.FailOnUnknownFileKeys {
+		if err := l.checkUnknownFileKeys(raw, ext); err != nil {
+			return err
+		}
+	}
+	if l.config.CaseInsensitiveKeys {
+		return l.decodeRaw(raw, ext, dst)
+	}
+
+	// values of the fields which cannot be set from a file are removed, see `source` tag
+	dropped := l.dropIgnoredValues(raw, ext)
+
+	// null values are removed, so decoders don't keep the previous values, see WithClearToken
+	nullValues := l.takeNullValues(raw, ext)
+
+	// decoders cannot parse strings like "5s" into time.Duration (and some of them into time.Time),
+	// "10MB" into `bytesize` and "75%" into `percent` fields,
+	// so such values are removed from the file and set by the loader itself
+	values := l.takeStringValues(raw, ext)
+	elemValues := l.takeElemValues(raw, ext)
+	implValues := l.takeImplValues(raw, ext)
+	if dropped || len(nullValues) > 0 || len(values) > 0 || len(elemValues) > 0 || len(implValues) > 0 {
+		for fd, v := range implValues {
+			v.orig, _ = lookupOrigValue(orig, filePath(fd, ext))
+			if sub, ok := v.value.(map[string]interface{}); ok {
+				pruneRaw(v.orig, sub)
+			}
+			implValues[fd] = v
+		}
+		// taken values are removed from the original tree, the rest of the file is decoded unchanged
+		pruneRaw(orig, raw)
+		if data, err = encodeData(orig, ext); err != nil {
+			return err
+		}
+	}
+
+	if err := decodeData(data, ext, dst); err != nil {
+		return err
+	}
+	if err := l.decodeImplValues(implValues, ext); err != nil {
+		return err
+	}
+	l.touchLazyFromFile(raw, ext)
+	l.markFileSources(raw, ext)
+	l.clearValues(nullValues)
+
+	for _, fd := range l.fields {
+		value, ok := values[fd]
+		if !ok {
+			continue
+		}
+		if err := l.setFieldData(fd, value); err != nil {
+			return fmt.Errorf("incorrect value %q of field %q: %w", value, fd.name, err)
+		}
+		l.setSource(fd, SourceFile, value)
+		l.touch(fd)
+	}
+	for _, fd := range l.fields {
+		value, ok := elemValues[fd]
+		if !ok {
+			continue
+		}
+		if err := setElemValues(fd, value); err != nil {
+			return fmt.Errorf("incorrect value of field %q: %w", fd.name, err)
+		}
+		l.setSource(fd, SourceFile, rawString(value))
+		l.touch(fd)
+	}
+
+	fillRemain(reflect.ValueOf(dst).Elem(), raw, ext, foldsKeys(ext))
+	return nil
+}
+
+// decodeRaw sets the fields from the decoded file one by one.
+func (l *Loader) decodeRaw(raw map[string]interface{}, ext string, dst interface{}) error {
+	for _, fd := range l.fields {
+		value, ok := lookupRawValue(raw, filePath(fd, ext))
+		if !ok {
+			continue
+		}
+		if !fd.allows(SourceFile) {
+			l.warnIgnored(fd, SourceFile, "value")
+			continue
+		}
+		if l.isNullValue(fd.field.Type, value) {
+			l.clearField(fd, value)
+			continue
+		}
+		if err := l.setFieldFromRaw(fd, value); err != nil {
+			return fmt.Errorf("incorrect value of field %q: %w", fd.name, err)
+		}
+		l.setSource(fd, SourceFile, rawString(value))
+		l.touch(fd)
+	}
+
+	// the fields are looked up ignoring case, see lookupRawValue
+	fillRemain(reflect.ValueOf(dst).Elem(), raw, ext, true)
+	return nil
+}
+
+// setFieldFromRaw sets a decoded value, only strings are parsed by the loader,
+// other values are converted via JSON.
+func (l *Loader) setFieldFromRaw(fd *fieldData, value interface{}) error {
+	switch value := value.(type) {
+	case string:
+		return l.setFieldData(fd, value)
+	case bool, int, int64, uint64, float64, json.Number:
+		if fd.value.Kind() == reflect.String {
+			return l.setFieldData(fd, fmt.Sprint(value))
+		}
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, fd.value.Addr().Interface())
+}
+
+// lookupRawValue finds a value by the path ignoring case of the keys,
+// keys with dots like `http.port` are matched to the nested path too.
+func lookupRawValue(raw map[string]interface{}, path []string) (interface{}, bool) {
+	for i := len(path); i > 0; i-- {
+		key, ok := findKey(raw, strings.Join(path[:i], "."))
+		if !ok {
+			continue
+		}
+		if i == len(path) {
+			return raw[key], true
+		}
+		if sub, ok := raw[key].(map[string]interface{}); ok {
+			if value, ok := lookupRawValue(sub, path[i:]); ok {
+				return value, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// takeStringValues removes from raw string values of time.Duration, time.Time, `bytesize`, `percent`, inline and `env_kv` fields
+// and numeric values (Unix timestamps) of time.Time fields.
+func (l *Loader) takeStringValues(raw map[string]interface{}, ext string) map[*fieldData]string {
+	values := map[*fieldData]string{}
+	for _, fd := range l.fields {
+		typ := fd.field.Type
+		for typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		if typ != durationType && typ != timeType && !isByteSize(fd) && !isPercent(fd) &&
+			inlineFormat(fd.field) == "" && !isEnvKV(fd.field) {
+			continue
+		}
+
+		container, key, ok := lookupFileKey(raw, fd, ext)
+		if !ok {
+			continue
+		}
+		switch value := container[key].(type) {
+		case string:
+			values[fd] = value
+			delete(container, key)
+		case int, int64, uint64, float64, json.Number:
+			// Unix timestamps, see setTime
+			if typ == timeType {
+				values[fd] = rawNumberString(value)
+				delete(container, key)
+			}
+		}
+	}
+	return values
+}
+
+// takeElemValues removes from raw lists and maps of durations and times, decoders cannot parse them either,
+// so they're set item by item like the values of env, see setElemValues.
+func (l *Loader) takeElemValues(raw map[string]interface{}, ext string) map[*fieldData]interface{} {
+	values := map[*fieldData]interface{}{}
+	for _, fd := range l.fields {
+		typ := fd.field.Type
+		if typ.Kind() != reflect.Slice && typ.Kind() != reflect.Map {
+			continue
+		}
+		if elem := typ.Elem(); elem != durationType && elem != timeType {
+			continue
+		}
+
+		container, key, ok := lookupFileKey(raw, fd, ext)
+		if !ok || !hasElemStrings(typ.Elem(), container[key]) {
+			continue
+		}
+		values[fd] = container[key]
+		delete(container, key)
+	}
+	return values
+}
+
+// hasElemStrings reports whether all the items of the list or the map can be parsed by the loader,
+// numbers are Unix timestamps for times, see setTime.
+func hasElemStrings(elem reflect.Type, value interface{}) bool {
+	var items []interface{}
+	switch value := value.(type) {
+	case []interface{}:
+		items = value
+	case map[string]interface{}:
+		for _, v := range value {
+			items = append(items, v)
+		}
+	default:
+		return false
+	}
+
+	for _, item := range items {
+		switch item.(type) {
+		case string:
+		case int, int64, uint64, float64, json.Number:
+			if elem != timeType {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// setElemValues sets a slice or a map from the items taken by takeElemValues.
+func setElemValues(field *fieldData, value interface{}) error {
+	switch value := value.(type) {
+	case []interface{}:
+		slice := reflect.MakeSlice(field.value.Type(), len(value), len(value))
+		for i, item := range value {
+			val := rawNumberString(item)
+			if err := setFieldDataHelper(newElemFieldData(field, slice.Index(i)), val); err != nil {
+				return fmt.Errorf("incorrect slice item %q: %w", val, err)
+			}
+		}
+		if field.merge && !field.value.IsNil() {
+			slice = reflect.AppendSlice(field.value, slice)
+		}
+		field.value.Set(slice)
+
+	case map[string]interface{}:
+		mapField := reflect.MakeMapWithSize(field.value.Type(), len(value))
+		if field.merge && !field.value.IsNil() {
+			mapField = field.value
+		}
+		mapData := &fieldData{name: field.name, field: field.field, value: mapField}
+		for key, item := range value {
+			if err := setMapEntry(mapData, key, rawNumberString(item)); err != nil {
+				return err
+			}
+		}
+		field.value.Set(mapField)
+	}
+	return nil
+}
+
+func rawNumberString(value interface{}) string {
+	if f, ok := value.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+// filePath returns keys of the field in a file of the given format.
+func filePath(fd *fieldData, ext string) []string {
+	var path []string
+	for f := fd; f != nil; f = f.parent {
+		path = append([]string{f.fileKey(ext)}, path...)
+	}
+	return path
+}
+
+// lookupFileKey finds a map containing the key of the field in a file of the given format,
+// the keys are matched like the decoder does, see foldsKeys.
+func lookupFileKey(raw map[string]interface{}, fd *fieldData, ext string) (map[string]interface{}, string, bool) {
+	return lookupPath(raw, filePath(fd, ext), foldsKeys(ext))
+}
+
+// lookupPath finds a map containing the last key of the path, fold is set to ignore case of the keys.
+func lookupPath(raw map[string]interface{}, path []string, fold bool) (map[string]interface{}, string, bool) {
+	for i, key := range path {
+		realKey, ok := findFileKey(raw, key, fold)
+		if !ok {
+			return nil, "", false
+		}
+		if i == len(path)-1 {
+			return raw, realKey, true
+		}
+		if raw, ok = raw[realKey].(map[string]interface{}); !ok {
+			return nil, "", false
+		}
+	}
+	return nil, "", false
+}
+
+// findFileKey finds the key, its case is ignored only with fold.
+func findFileKey(raw map[string]interface{}, key string, fold bool) (string, bool) {
+	if !fold {
+		_, ok := raw[key]
+		return key, ok
+	}
+	return findKey(raw, key)
+}
+
+func findKey(raw map[string]interface{}, key string) (string, bool) {
+	if _, ok := raw[key]; ok {
+		return key, true
+	}
+	for k := range raw {
+		if strings.EqualFold(k, key) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+func encodeData(raw interface{}, ext string) ([]byte, error) {
+	switch ext {
+	case ".yaml", ".yml":
+		return yaml.Marshal(raw)
+	case ".json":
+		return json.Marshal(raw)
+	case ".toml":
+		var buf bytes.Buffer
+		err := toml.NewEncoder(&buf).Encode(raw)
+		return buf.Bytes(), err
+	default:
+		return nil, unsupportedFormatError("", ext)
+	}
+}
+
+func decodeData(data []byte, ext string, dst interface{}) error {
+	switch ext {
+	case ".yaml", ".yml":
+		return yaml.Unmarshal(data, dst)
+	case ".json":
+		return json.Unmarshal(data, dst)
+	case ".toml":
+		_, err := toml.Decode(string(data), dst)
+		return err
+	default:
+		return unsupportedFormatError("", ext)
+	}
+}
+
+// decodeRawData decodes the file into a map without losing data:
+// JSON numbers are kept as json.Number, YAML keys keep their types.
+func decodeRawData(data []byte, ext string) (map[string]interface{}, error) {
+	var raw map[string]interface{}
+	if ext != ".json" {
+		err := decodeData(data, ext, &raw)
+		return raw, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid data after top-level value")
+	}
+	return raw, nil
+}
+
+// pruneRaw removes from orig the keys which aren't in its normalized copy anymore.
+func pruneRaw(orig interface{}, raw map[string]interface{}) {
+	switch orig := orig.(type) {
+	case map[string]interface{}:
+		for k, v := range orig {
+			if rv, ok := raw[k]; !ok {
+				delete(orig, k)
+			} else if sub, ok := rv.(map[string]interface{}); ok {
+				pruneRaw(v, sub)
+			}
+		}
+	case map[interface{}]interface{}:
+		for k, v := range orig {
+			if rv, ok := raw[fmt.Sprint(k)]; !ok {
+				delete(orig, k)
+			} else if sub, ok := rv.(map[string]interface{}); ok {
+				pruneRaw(v, sub)
+			}
+		}
+	}
+}
+
+// lookupOrigValue finds a value by the path in the tree which isn't normalized, see decodeRawData.
+func lookupOrigValue(val interface{}, path []string) (interface{}, bool) {
+	for _, key := range path {
+		var ok bool
+		if val, ok = origChild(val, key); !ok {
+			return nil, false
+		}
+	}
+	return val, true
+}
+
+func origChild(val interface{}, key string) (interface{}, bool) {
+	switch val := val.(type) {
+	case map[string]interface{}:
+		if k, ok := findKey(val, key); ok {
+			return val[k], true
+		}
+	case map[interface{}]interface{}:
+		var found interface{}
+		ok := false
+		for k, v := range val {
+			switch name := fmt.Sprint(k); {
+			case name == key:
+				return v, true
+			case strings.EqualFold(name, key):
+				found, ok = v, true
+			}
+		}
+		return found, ok
+	}
+	return nil, false
+}
+
+func isSupportedFormat(ext string) bool {
+	for _, format := range supportedFormats {
+		if ext == format {
+			return true
+		}
+	}
+	return false
+}
+
+func unsupportedFormatError(file, ext string) error {
+	supported := strings.Join(supportedFormats, ", ")
+	switch {
+	case file == "":
+		return fmt.Errorf("file format %q isn't supported, supported formats: %s", ext, supported)
+	case ext == "":
+		return fmt.Errorf("file %q has no extension, set default format to one of: %s", file, supported)
+	default:
+		return fmt.Errorf("file %q has unsupported format %q, supported formats: %s", file, ext, supported)
+	}
+}
+
+// fillRemain puts file keys that aren't mapped to any struct field
+// into the field marked with `aconfig:",remain"` tag (if there is one).
+// Keys are compared like the decoder does, fold is set when the case of the keys is ignored.
+func fillRemain(value reflect.Value, raw map[string]interface{}, ext string, fold bool) {
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return
+	}
+
+	var remain reflect.Value
+	known := map[string]bool{}
+	collectKnownKeys(value, ext, known, &remain)
+
+	typ := value.Type()
+	for i := 0; i < value.NumField(); i++ {
+		field := typ.Field(i)
+		if field.Anonymous || !value.Field(i).CanSet() || isRemainField(field) {
+			continue
+		}
+		name := fileKeyName(field, ext)
+		sub := raw[name]
+		if fold {
+			sub = lookupKey(raw, name)
+		}
+		if sub, ok := sub.(map[string]interface{}); ok {
+			fillRemain(value.Field(i), sub, ext, fold)
+		}
+	}
+
+	if !remain.IsValid() || remain.Type() != reflect.TypeOf(map[string]interface{}{}) {
+		return
+	}
+	for key, val := range raw {
+		if isKnownKey(known, key, fold) {
+			continue
+		}
+		if remain.IsNil() {
+			remain.Set(reflect.MakeMap(remain.Type()))
+		}
+		remain.SetMapIndex(reflect.ValueOf(key), reflect.ValueOf(floatNumbers(normalizeRaw(val))))
+	}
+}
+
+func collectKnownKeys(value reflect.Value, ext string, known map[string]bool, remain *reflect.Value) {
+	typ := value.Type()
+	for i := 0; i < value.NumField(); i++ {
+		field := typ.Field(i)
+		if !value.Field(i).CanSet() {
+			continue
+		}
+		if isRemainField(field) {
+			*remain = value.Field(i)
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			collectKnownKeys(value.Field(i), ext, known, remain)
+			continue
+		}
+		if name := fileKeyName(field, ext); name != "-" {
+			known[name] = true
+		}
+	}
+}
+
+func isKnownKey(known map[string]bool, key string, fold bool) bool {
+	if known[key] {
+		return true
+	}
+	if !fold {
+		return false
+	}
+	for k := range known {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// foldsKeys reports whether the decoder of the format matches the keys ignoring case,
+// JSON and TOML decoders do it, YAML decoder doesn't.
+func foldsKeys(ext string) bool {
+	return ext != ".yaml" && ext != ".yml"
+}
+
+func isRemainField(field reflect.StructField) bool {
+	opts := strings.Split(field.Tag.Get(aconfigTag), ",")
+	for _, opt := range opts[1:] {
+		if opt == "remain" {
+			return true
+		}
+	}
+	return false
+}
+
+// fileKeyName returns a name of the field in a file of the given format, the one the decoder looks for:
+// YAML decoder uses the lowercased field name when there is no tag.
+func fileKeyName(field reflect.StructField, ext string) string {
+	if name := fileTagName(field, ext); name != "" {
+		return name
+	}
+	if ext == ".yaml" || ext == ".yml" {
+		return strings.ToLower(field.Name)
+	}
+	return field.Name
+}
+
+// fileTagName returns a name set in the struct tag for the given file format.
+func fileTagName(field reflect.StructField, ext string) string {
+	tag := strings.TrimPrefix(ext, ".")
+	if tag == "yml" {
+		tag = "yaml"
+	}
+	if tag == "" {
+		return ""
+	}
+	return strings.Split(field.Tag.Get(tag), ",")[0]
+}
+
+func lookupKey(raw map[string]interface{}, key string) interface{} {
+	if k, ok := findKey(raw, key); ok {
+		return normalizeRaw(raw[k])
+	}
+	return nil
+}
+
+// normalizeRaw returns a copy of the value with YAML-specific maps converted into map[string]interface{}.
+func normalizeRaw(val interface{}) interface{} {
+	switch val := val.(type) {
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(val))
+		for k, v := range val {
+			res[fmt.Sprint(k)] = normalizeRaw(v)
+		}
+		return res
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(val))
+		for k, v := range val {
+			res[k] = normalizeRaw(v)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(val))
+		for i, v := range val {
+			res[i] = normalizeRaw(v)
+		}
+		return res
+	default:
+		return val
+	}
+}
+
+// floatNumbers converts json.Number in the normalized value into float64 like json.Unmarshal does.
+func floatNumbers(val interface{}) interface{} {
+	switch val := val.(type) {
+	case json.Number:
+		f, _ := val.Float64()
+		return f
+	case map[string]interface{}:
+		for k, v := range val {
+			val[k] = floatNumbers(v)
+		}
+	case []interface{}:
+		for i, v := range val {
+			val[i] = floatNumbers(v)
+		}
+	}
+	return val
+}
+
+func (l *Loader) loadEnvironment() error {
+	if l.config.FailOnUnknownEnv {
+		if err := l.checkUnknownEnv(); err != nil {
+			return err
+		}
+	}
+
+	for _, field := range l.fields {
+		if !field.allows(SourceEnv) {
+			if envName, _, ok := l.lookupEnv(field); ok {
+				l.warnIgnored(field, SourceEnv, fmt.Sprintf("%q", envName))
+			}
+			continue
+		}
+		if field.Tag(envCollectTag) != "" {
+			if err := l.loadEnvCollect(field); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := l.loadEnvMapEntries(field, l.getEnvName(field)); err != nil {
+			return err
+		}
+		envName, v, ok := l.lookupEnv(field)
+		if !ok {
+			continue
+		}
+		// empty env clears the field, see EmptyEnvClears
+		if v == "" {
+			field.value.Set(reflect.Zero(field.value.Type()))
+			l.setSource(field, SourceEnv, v)
+			l.touch(field)
+			continue
+		}
+		if field.envFormat == "json" {
+			if err := json.Unmarshal([]byte(v), field.value.Addr().Interface()); err != nil {
+				return fmt.Errorf("incorrect JSON in env %q for field %q: %w", envName, field.name, err)
+			}
+			l.setSource(field, SourceEnv, v)
+			l.touch(field)
+			continue
+		}
+		if err := l.setFieldData(field, v); err != nil {
+			return err
+		}
+		l.setSource(field, SourceEnv, v)
+		l.touch(field)
+	}
+	return nil
+}
+
+// checkDuplicateNames returns an error if different fields have the same env or flag name,
+// which happens with embedded structs or `env` and `flag` tags.
+func (l *Loader) checkDuplicateNames() error {
+	var msgs []string
+	if !l.config.SkipEnv {
+		msgs = append(msgs, duplicateNames("env", l.fields, func(field *fieldData) []string {
+			return append([]string{l.getEnvName(field)}, l.getEnvAliases(field)...)
+		})...)
+	}
+	if !l.config.SkipFlag {
+		msgs = append(msgs, duplicateNames("flag", l.fields, func(field *fieldData) []string {
+			return append([]string{l.getFlagName(field)}, l.getFlagAliases(field)...)
+		})...)
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+func duplicateNames(kind string, fields []*fieldData, namesOf func(*fieldData) []string) []string {
+	owners := map[string][]string{}
+	var names []string
+	for _, field := range fields {
+		path := goPath(field)
+		for _, name := range namesOf(field) {
+			if len(owners[name]) == 0 {
+				names = append(names, name)
+			}
+			if !containsString(owners[name], path) {
+				owners[name] = append(owners[name], path)
+			}
+		}
+	}
 
-	if err := l.loadSources(into); err != nil {
-		return fmt.Errorf("aconfig: cannot load config: %w", err)
+	var msgs []string
+	for _, name := range names {
+		if paths := owners[name]; len(paths) > 1 {
+			msgs = append(msgs, fmt.Sprintf("duplicate %s name %q of fields %q", kind, name, paths))
+		}
 	}
-	return nil
+	return msgs
 }
 
-// LoadWithFile configuration into a given param.
-func (l *Loader) LoadWithFile(into interface{}, file string) error {
-	l.config.Files = []string{file}
-
-	return l.Load(into)
+// goPath returns full path of the field in Go code, embedded structs are included.
+func goPath(fd *fieldData) string {
+	var path []string
+	for f := fd; f != nil; f = f.parent {
+		path = append([]string{f.field.Name}, path...)
+		for e := f.embedded; e != nil; e = e.embedded {
+			path = append([]string{e.field.Name}, path...)
+		}
+	}
+	return strings.Join(path, ".")
 }
 
-func (l *Loader) loadSources(into interface{}) error {
-	if !l.config.SkipDefaults {
-		if err := l.loadDefaults(); err != nil {
-			return err
+// lookupEnv returns value of the field env or of the first set alias from `env_aliases` tag.
+func (l *Loader) lookupEnv(field *fieldData) (string, string, bool) {
+	for _, envName := range l.getEnvNames(field) {
+		if v, ok := l.getEnv(envName); ok {
+			field.usedAlias = ""
+			return envName, v, true
 		}
 	}
-	if !l.config.SkipFile {
-		if err := l.loadFromFile(into); err != nil {
-			return err
+	for _, alias := range l.getEnvAliases(field) {
+		if v, ok := l.getEnv(alias); ok {
+			field.usedAlias = alias
+			return alias, v, true
 		}
 	}
-	if !l.config.SkipEnv {
-		if err := l.loadEnvironment(); err != nil {
-			return err
-		}
+	return "", "", false
+}
+
+func (l *Loader) getEnv(name string) (string, bool) {
+	v, ok := l.lookupOSEnv(name)
+	if !l.config.EmptyEnvClears && v == "" {
+		return "", false
 	}
-	if !l.config.SkipFlag {
-		if err := l.loadFlags(); err != nil {
-			return err
-		}
+	return v, ok
+}
+
+// lookupOSEnv returns a variable from the process environment or from WithEnv.
+func (l *Loader) lookupOSEnv(name string) (string, bool) {
+	if l.config.Env == nil {
+		return os.LookupEnv(name)
 	}
-	return nil
+	v, ok := l.config.Env[name]
+	return v, ok
 }
 
-func (l *Loader) loadDefaults() error {
-	for _, fd := range l.fields {
-		if err := l.setFieldData(fd, fd.defaultValue); err != nil {
-			return err
-		}
+// environ returns variables like `KEY=value` from the process environment or from WithEnv.
+func (l *Loader) environ() []string {
+	if l.config.Env == nil {
+		return os.Environ()
 	}
-	return nil
+	env := make([]string, 0, len(l.config.Env))
+	for name, value := range l.config.Env {
+		env = append(env, name+"="+value)
+	}
+	return env
 }
 
-func (l *Loader) loadFromFile(dst interface{}) error {
-	for _, file := range l.config.Files {
-		f, err := os.Open(file)
-		if err != nil {
-			if l.config.ShouldStopOnFileError {
-				return err
-			}
+// loadEnvMapEntries adds entries like `LABELS_TEAM=payments` to a map field marked with `merge:"true"` tag.
+// Keys are lowercased, 'cause environment variables are upper case.
+func (l *Loader) loadEnvMapEntries(field *fieldData, envName string) error {
+	if !isMergeMap(field) {
+		return nil
+	}
+
+	var names []string
+	values := map[string]string{}
+	for _, env := range l.environ() {
+		kv := strings.SplitN(env, "=", 2)
+		if len(kv) != 2 || !strings.HasPrefix(kv[0], envName+"_") {
 			continue
 		}
-		defer func() { _ = f.Close() }()
-
-		ext := strings.ToLower(filepath.Ext(file))
-		switch ext {
-		case ".yaml", ".yml":
-			err = yaml.NewDecoder(f).Decode(dst)
-		case ".json":
-			err = json.NewDecoder(f).Decode(dst)
-		case ".toml":
-			_, err = toml.DecodeReader(f, dst)
-		default:
-			return fmt.Errorf("file format '%q' isn't supported", ext)
+		if !l.config.EmptyEnvClears && kv[1] == "" {
+			continue
 		}
+		names = append(names, kv[0])
+		values[kv[0]] = kv[1]
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
 
-		if err == nil {
-			return nil
-		}
-		if l.config.ShouldStopOnFileError {
-			return fmt.Errorf("file parsing error: %w", err)
+	if field.value.IsNil() {
+		field.value.Set(reflect.MakeMap(field.value.Type()))
+	}
+	entries := make([]string, 0, len(names))
+	for _, name := range names {
+		key := strings.ToLower(strings.TrimPrefix(name, envName+"_"))
+		if err := setMapEntry(field, key, values[name]); err != nil {
+			return fmt.Errorf("incorrect env %q for field %q: %w", name, field.name, err)
 		}
+		entries = append(entries, key+":"+values[name])
 	}
+	l.setSource(field, SourceEnv, strings.Join(entries, ","))
+	l.touch(field)
 	return nil
 }
 
-func (l *Loader) loadEnvironment() error {
+func isMergeMap(field *fieldData) bool {
+	return field.merge && field.value.Kind() == reflect.Map
+}
+
+// checkUnknownEnv returns an error for prefixed environment variables without a field.
+func (l *Loader) checkUnknownEnv() error {
+	prefixes := l.envPrefixes()
+	for i, prefix := range prefixes {
+		prefixes[i] = strings.ToUpper(prefix)
+	}
+	if prefixes[0] == "" {
+		return nil
+	}
+
+	known := make(map[string]bool, len(l.fields)+len(l.config.AllowedEnv))
+	names := make([]string, 0, len(l.fields))
+	var mapPrefixes []string
 	for _, field := range l.fields {
-		envName := l.getEnvName(field)
-		v, ok := os.LookupEnv(envName)
-		if !ok {
-			continue
+		for _, name := range l.getEnvNames(field) {
+			known[name] = true
+			names = append(names, name)
 		}
-		if err := l.setFieldData(field, v); err != nil {
-			return err
+		if isMergeMap(field) {
+			mapPrefixes = append(mapPrefixes, l.getEnvName(field)+"_")
+		}
+		mapPrefixes = append(mapPrefixes, l.envCollectPrefixes(field)...)
+		for _, alias := range l.getEnvAliases(field) {
+			known[alias] = true
 		}
 	}
-	return nil
+	for _, name := range l.config.AllowedEnv {
+		known[strings.ToUpper(name)] = true
+	}
+
+	var unknown []string
+	for _, env := range l.environ() {
+		name := strings.ToUpper(strings.SplitN(env, "=", 2)[0])
+		if hasAnyPrefix(name, prefixes) && !known[name] && !hasAnyPrefix(name, mapPrefixes) {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	sort.Strings(unknown)
+	msgs := make([]string, 0, len(unknown))
+	for _, name := range unknown {
+		msg := fmt.Sprintf("%q", name)
+		if suggest := closestName(name, names); suggest != "" {
+			msg += fmt.Sprintf(" (did you mean %q?)", suggest)
+		}
+		msgs = append(msgs, msg)
+	}
+	return fmt.Errorf("unknown environment variables: %s", strings.Join(msgs, ", "))
 }
 
 func (l *Loader) loadFlags() error {
@@ -283,18 +2114,140 @@ func (l *Loader) loadFlags() error {
 	})
 
 	for _, field := range l.fields {
-		flagName := l.getFlagName(field)
-		flg, ok := actualFlags[flagName]
+		if !field.allows(SourceFlag) {
+			continue
+		}
+		value, ok, err := l.lookupFlagValue(field, actualFlags)
+		if err != nil {
+			return err
+		}
 		if !ok {
 			continue
 		}
-		if err := l.setFieldData(field, flg.Value.String()); err != nil {
+		if err := l.setFieldData(field, value); err != nil {
 			return err
 		}
+		l.setSource(field, SourceFlag, value)
+		l.touch(field)
 	}
 	return nil
 }
 
+// lookupFlagValue returns a value of the field flag, the negated flag gives false, see WithNegatedFlags.
+func (l *Loader) lookupFlagValue(field *fieldData, actualFlags map[string]*flag.Flag) (string, bool, error) {
+	flg, ok := l.lookupFlag(field, actualFlags)
+
+	var neg *flag.Flag
+	if l.config.NegatedFlags && isBoolField(field) {
+		neg = actualFlags[l.negatedFlagName(l.getFlagName(field))]
+	}
+
+	switch {
+	case ok && neg != nil:
+		return "", false, fmt.Errorf("flags -%s and -%s cannot be used together", flg.Name, neg.Name)
+	case ok:
+		return flg.Value.String(), true, nil
+	case neg != nil:
+		negated, err := strconv.ParseBool(neg.Value.String())
+		if err != nil {
+			return "", false, err
+		}
+		field.usedAlias = ""
+		return strconv.FormatBool(!negated), true, nil
+	default:
+		return "", false, nil
+	}
+}
+
+// lookupFlag returns the field flag or the first set alias from `flag_aliases` tag.
+func (l *Loader) lookupFlag(field *fieldData, actualFlags map[string]*flag.Flag) (*flag.Flag, bool) {
+	if flg, ok := actualFlags[l.getFlagName(field)]; ok {
+		field.usedAlias = ""
+		return flg, true
+	}
+	for _, alias := range l.getFlagAliases(field) {
+		if flg, ok := actualFlags[alias]; ok {
+			field.usedAlias = "-" + alias
+			return flg, true
+		}
+	}
+	return nil, false
+}
+
+// touch marks lazy pointers of the field as set by a source.
+func (l *Loader) touch(field *fieldData) {
+	for f := field; f != nil; f = f.parent {
+		for e := f; e != nil; e = e.embedded {
+			if e.lazyValue.IsValid() {
+				e.touched = true
+			}
+		}
+	}
+}
+
+// releaseLazy sets lazy pointers back to nil if no source touched them.
+func (l *Loader) releaseLazy() {
+	for _, fd := range l.lazy {
+		if fd.touched {
+			fd.value.Set(fd.lazyValue)
+		} else {
+			fd.value.Set(reflect.Zero(fd.value.Type()))
+		}
+	}
+}
+
+// touchLazyFromFile marks lazy pointers present in the file.
+// Some decoders create a new struct instead of using the allocated one,
+// then its content is copied, so the fields stay bound to the allocated struct.
+func (l *Loader) touchLazyFromFile(raw map[string]interface{}, ext string) {
+	for _, fd := range l.lazy {
+		if container, key, ok := lookupFileKey(raw, fd, ext); ok && container[key] != nil {
+			l.touch(fd)
+		}
+		if !fd.value.IsNil() && fd.value.Pointer() != fd.lazyValue.Pointer() {
+			fd.lazyValue.Elem().Set(fd.value.Elem())
+		}
+		fd.value.Set(fd.lazyValue)
+	}
+}
+
+// warnDeprecated logs a warning for every field with `deprecated` tag set by a source.
+// For a field with aliases the warning is logged only when an alias is used.
+func (l *Loader) warnDeprecated() {
+	for _, fd := range l.fields {
+		switch {
+		case fd.deprecated == "" || fd.source == SourceUnset || fd.source == SourceDefault:
+		case fd.usedAlias != "":
+			l.warnf("aconfig: %q of field %q is deprecated: %s", fd.usedAlias, fd.name, fd.deprecated)
+		case fd.envAliases == "" && fd.flagAliases == "":
+			l.warnf("aconfig: field %q set from %s is deprecated: %s", fd.name, fd.source, fd.deprecated)
+		}
+	}
+}
+
+func (l *Loader) warnf(format string, args ...interface{}) {
+	if l.config.Logger == nil {
+		log.Printf(format, args...)
+		return
+	}
+	l.config.Logger(format, args...)
+}
+
+// fileFormat returns the format (extension) of the first configuration file.
+func (l *Loader) fileFormat() string {
+	files := l.configFiles()
+	if len(files) == 0 {
+		return ""
+	}
+	if files[0] == stdinFile && l.config.StdinFormat != "" {
+		return l.config.StdinFormat
+	}
+	if ext := fileExt(files[0]); ext != "" {
+		return ext
+	}
+	return l.config.DefaultFormat
+}
+
 func (l *Loader) assertBuilt() {
 	if !l.isBuilt {
 		panic("aconfig: you must run Build method before using the loader")
@@ -302,62 +2255,195 @@ func (l *Loader) assertBuilt() {
 }
 
 func (l *Loader) getEnvName(field *fieldData) string {
+	return strings.ToUpper(l.config.EnvPrefix + l.envBaseName(field))
+}
+
+// getEnvNames returns env names of the field for all the prefixes, see WithEnvPrefixes.
+func (l *Loader) getEnvNames(field *fieldData) []string {
+	name := l.envBaseName(field)
+	prefixes := l.envPrefixes()
+	names := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		names = append(names, strings.ToUpper(prefix+name))
+	}
+	return names
+}
+
+func (l *Loader) envPrefixes() []string {
+	return append([]string{l.config.EnvPrefix}, l.config.EnvFallbackPrefixes...)
+}
+
+// envBaseName returns env name of the field without a prefix.
+func (l *Loader) envBaseName(field *fieldData) string {
 	name := field.name
-	if field.envName != "" {
+	switch {
+	case field.envName != "":
 		name = field.envName
+	case l.config.SplitEnvWords:
+		name = splitNameWords(name, "_")
+	}
+
+	sep := l.config.NestingSeparator
+	if sep == "" {
+		sep = "_"
 	}
-	return strings.ToUpper(l.config.EnvPrefix + strings.ReplaceAll(name, ".", "_"))
+	return strings.ReplaceAll(name, ".", sep)
 }
 
 func (l *Loader) getFlagName(field *fieldData) string {
 	name := field.name
-	if field.flagName != "" {
+	switch {
+	case field.flagName != "":
 		name = field.flagName
+	case l.config.FlagWordsSeparator != "":
+		name = splitNameWords(name, l.config.FlagWordsSeparator)
+	}
+	if field.flagName == "" && l.config.NestingSeparator != "" {
+		name = strings.ReplaceAll(name, ".", l.config.NestingSeparator)
 	}
 	return strings.ToLower(l.config.FlagPrefix + name)
 }
 
+// getEnvAliases returns env names from `env_aliases` tag, the prefixes are added like to `env` tag.
+func (l *Loader) getEnvAliases(field *fieldData) []string {
+	var names []string
+	for _, alias := range splitTagList(field.envAliases) {
+		for _, prefix := range l.envPrefixes() {
+			names = append(names, strings.ToUpper(prefix+alias))
+		}
+	}
+	return names
+}
+
+// getFlagAliases returns flag names from `flag_aliases` tag, the prefix is added like to `flag` tag.
+func (l *Loader) getFlagAliases(field *fieldData) []string {
+	var names []string
+	for _, alias := range splitTagList(field.flagAliases) {
+		names = append(names, strings.ToLower(l.config.FlagPrefix+alias))
+	}
+	return names
+}
+
 func (l *Loader) setFieldData(field *fieldData, value string) error {
 	return setFieldDataHelper(field, value)
 }
 
-func getFields(x interface{}) []*fieldData {
+// getFields returns fields of the struct, there are no fields for other types.
+// Fields of a walked type are cached, see fieldLayout.
+func (l *Loader) getFields(x interface{}) []*fieldData {
+	l.lazy = nil
+	l.impls = nil
+	l.structPtrs = nil
+	l.walking = map[reflect.Type]bool{}
+
 	value := reflect.ValueOf(x)
-	for value.Type().Kind() == reflect.Ptr {
+	for value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			value = reflect.New(value.Type().Elem())
+		}
 		value = value.Elem()
 	}
 	if value.Kind() != reflect.Struct {
-		panic("aconfig: only struct can be passed to the loader")
+		return nil
+	}
+
+	key := layoutKey{typ: value.Type(), format: l.fileFormat()}
+	if layout, ok := l.layouts[key]; ok {
+		return l.bindLayout(layout, value)
+	}
+
+	fields := l.getFieldsHelper(value, nil, nil)
+	l.cacheLayout(key, fields)
+	return fields
+}
+
+// checkTarget returns an error if the value cannot be loaded.
+// Only files are supported for a non-struct value, like a slice or a map.
+func (l *Loader) checkTarget(into interface{}) error {
+	value := reflect.ValueOf(into)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return fmt.Errorf("aconfig: Load requires a non-nil pointer, got %T", into)
+	}
+	typ := value.Type().Elem()
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	switch {
+	case typ.Kind() == reflect.Struct:
+		return nil
+	case isUnsupportedType(typ) || typ.Kind() == reflect.Interface:
+		return fmt.Errorf("aconfig: unsupported top-level type %s", typ)
+	case !l.config.SkipEnv || !l.config.SkipFlag:
+		return fmt.Errorf("aconfig: unsupported top-level type %s for env and flags, skip them to load only files", typ)
+	default:
+		return nil
+	}
+}
+
+func isStructTarget(x interface{}) bool {
+	typ := reflect.TypeOf(x)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
 	}
-	return getFieldsHelper(value, nil)
+	return typ != nil && typ.Kind() == reflect.Struct
 }
 
-func getFieldsHelper(valueObject reflect.Value, parent *fieldData) []*fieldData {
+// getFieldsHelper walks the struct, embedded is a field of the embedded struct being walked (if any).
+func (l *Loader) getFieldsHelper(valueObject reflect.Value, parent, embedded *fieldData) []*fieldData {
 	typeObject := valueObject.Type()
 	count := valueObject.NumField()
+	l.walking[typeObject] = true
+	defer delete(l.walking, typeObject)
 
 	fields := make([]*fieldData, 0, count)
 	for i := 0; i < count; i++ {
 		value := valueObject.Field(i)
 		field := typeObject.Field(i)
 
-		if !value.CanSet() {
+		if !value.CanSet() || isRemainField(field) || isUnsupportedType(field.Type) {
 			continue
 		}
 
-		// TODO: pointers
+		fd := l.newFieldData(field, value, parent)
+		fd.embedded = embedded
+		fd.index = fieldIndex(parent, embedded, i)
+
+		// interface is walked when it holds an implementation, see RegisterImpl
+		if isImplField(field) {
+			fd.implSelector = valueObject.FieldByName(field.Tag.Get(implTag))
+			l.impls = append(l.impls, fd)
+			if impl := value.Elem(); impl.Kind() == reflect.Ptr && impl.Elem().Kind() == reflect.Struct {
+				fields = append(fields, l.getFieldsHelper(impl.Elem(), fd, nil)...)
+			}
+			continue
+		}
 
-		fd := newFieldData(field, value, parent)
+		// pointer to a struct is allocated for walking and released after loading
+		// if no source sets any of its fields, see touch and releaseLazy
+		// fields of an embedded pointer are flattened like the fields of an embedded struct,
+		// so it's always allocated, its fields are promoted and must be accessible
+		// a pointer to a struct being walked (like `Next *Node`) isn't expanded, it's a single field
+		if isStructPtr(field) && !l.walking[field.Type.Elem()] {
+			l.structPtrs = append(l.structPtrs, fd)
+			if value.IsNil() {
+				l.allocStructPtr(fd)
+			}
+			if field.Anonymous {
+				fields = append(fields, l.getFieldsHelper(value.Elem(), parent, fd)...)
+			} else {
+				fields = append(fields, l.getFieldsHelper(value.Elem(), fd, nil)...)
+			}
+			continue
+		}
 
 		// if just a field - add and process next, else expand struct
-		if field.Type.Kind() == reflect.Struct {
-			var subFieldParent *fieldData
+		if field.Type.Kind() == reflect.Struct && !isLeafStruct(field) {
 			if field.Anonymous {
-				subFieldParent = parent
+				fields = append(fields, l.getFieldsHelper(value, parent, fd)...)
 			} else {
-				subFieldParent = fd
+				fields = append(fields, l.getFieldsHelper(value, fd, nil)...)
 			}
-			fields = append(fields, getFieldsHelper(value, subFieldParent)...)
 			continue
 		}
 		fields = append(fields, fd)
@@ -365,32 +2451,153 @@ func getFieldsHelper(valueObject reflect.Value, parent *fieldData) []*fieldData
 	return fields
 }
 
+// allocStructPtr allocates a nil pointer to a struct, the pointer of a named field is lazy.
+func (l *Loader) allocStructPtr(fd *fieldData) {
+	ptr := reflect.New(fd.value.Type().Elem())
+	fd.value.Set(ptr)
+	if !fd.field.Anonymous {
+		fd.lazyValue = ptr
+		l.lazy = append(l.lazy, fd)
+	}
+}
+
+// isStructPtr reports whether the field is a pointer to an expandable struct.
+func isStructPtr(field reflect.StructField) bool {
+	if field.Type.Kind() != reflect.Ptr {
+		return false
+	}
+	elemField := field
+	elemField.Type = field.Type.Elem()
+	return elemField.Type.Kind() == reflect.Struct && !isLeafStruct(elemField)
+}
+
+// isLeafStruct reports whether the struct field is set as a single value.
+func isLeafStruct(field reflect.StructField) bool {
+	return field.Type == timeType || field.Tag.Get(envFormatTag) == "json" || inlineFormat(field) != "" || isEnvKV(field)
+}
+
+// isUnsupportedType reports whether values of the type cannot be set from any source.
+func isUnsupportedType(typ reflect.Type) bool {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return true
+	default:
+		return false
+	}
+}
+
 type fieldData struct {
 	name         string
 	parent       *fieldData
+	embedded     *fieldData
 	field        reflect.StructField
 	value        reflect.Value
 	defaultValue string
 	envName      string
 	flagName     string
+	kvName       string
+	fileName     string
+	fileKeys     map[string]string
+	envFormat    string
+	defaultIf    string
 	usage        string
+	deprecated   string
+	transform    string
+	envAliases   string
+	flagAliases  string
+	usedAlias    string
+	source       Source
+	merge        bool
+
+	// lazyValue is an allocated struct for a nil pointer field,
+	// touched is set when a source sets any of its fields.
+	lazyValue reflect.Value
+	touched   bool
+
+	// implSelector is a field with a name of the implementation, see RegisterImpl
+	implSelector reflect.Value
+
+	// index of the field from the loaded struct, see fieldLayout
+	index []int
+
+	// sources which can set the field, any source for SourceUnset, see `source` tag
+	sources Source
+}
+
+// isReleased reports whether the field is inside a pointer that stays nil after loading.
+func (f *fieldData) isReleased() bool {
+	for p := f; p != nil; p = p.parent {
+		for e := p; e != nil; e = e.embedded {
+			if e.lazyValue.IsValid() && !e.touched {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-func newFieldData(field reflect.StructField, value reflect.Value, parent *fieldData) *fieldData {
+func (l *Loader) newFieldData(field reflect.StructField, value reflect.Value, parent *fieldData) *fieldData {
+	fileName := fileTagName(field, l.fileFormat())
+
+	name := field.Name
+	if l.config.UseFileTags && fileName != "" && fileName != "-" {
+		name = fileName
+	}
+
+	// incorrect tag is reported by checkSourceTags
+	sources, _ := parseSources(field.Tag.Get(sourceTag))
+
 	return &fieldData{
-		name:         makeName(field.Name, parent),
+		name:         makeName(name, parent),
 		parent:       parent,
 		value:        value,
 		field:        field,
-		defaultValue: field.Tag.Get(defaultValueTag),
+		defaultValue: l.getDefaultValue(field),
 		envName:      field.Tag.Get(envNameTag),
 		flagName:     field.Tag.Get(flagNameTag),
+		kvName:       field.Tag.Get(kvNameTag),
+		fileName:     fileName,
+		fileKeys:     fileKeysOf(field),
+		envFormat:    field.Tag.Get(envFormatTag),
+		defaultIf:    field.Tag.Get(defaultIfTag),
 		usage:        field.Tag.Get(usageTag),
+		deprecated:   field.Tag.Get(deprecatedTag),
+		transform:    field.Tag.Get(transformTag),
+		envAliases:   field.Tag.Get(envAliasesTag),
+		flagAliases:  field.Tag.Get(flagAliasesTag),
+		merge:        field.Tag.Get(mergeTag) == "true",
+		sources:      sources,
+	}
+}
+
+func (l *Loader) getDefaultValue(field reflect.StructField) string {
+	tag := l.config.DefaultValueTag
+	if tag == "" {
+		tag = defaultValueTag
+	}
+
+	if l.config.Profile != "" {
+		if value, ok := field.Tag.Lookup(tag + "_" + l.config.Profile); ok {
+			return value
+		}
 	}
+	return field.Tag.Get(tag)
 }
 
-func newSimpleFieldData(value reflect.Value) *fieldData {
-	return newFieldData(reflect.StructField{}, value, nil)
+// newElemFieldData creates a field data for an element of a slice or a map.
+// Tags of the field are kept and the type is set to the element type,
+// so elements are parsed with the same rules as the field itself.
+func newElemFieldData(field *fieldData, value reflect.Value) *fieldData {
+	elemField := field.field
+	elemField.Type = value.Type()
+	return &fieldData{
+		name:  field.name,
+		field: elemField,
+		value: value,
+	}
 }
 
 func makeName(name string, parent *fieldData) string {
@@ -420,19 +2627,54 @@ func (f *fieldData) Parent() (Field, bool) {
 	return f.parent, f.parent != nil
 }
 
-func setFieldDataHelper(field *fieldData, value string) error {
-	// unwrap pointers
-	for field.value.Type().Kind() == reflect.Ptr {
-		if field.value.IsNil() {
-			field.value.Set(reflect.New(field.value.Type().Elem()))
+// ParseInto parses a value into dst with the same rules the loader uses for fields.
+// dst must be a non-nil pointer, like `ParseInto(&timeout, "5s")`.
+func ParseInto(dst interface{}, value string) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("aconfig: ParseInto requires a non-nil pointer, got %T", dst)
+	}
+
+	elem := v.Elem()
+	field := &fieldData{
+		field: reflect.StructField{Type: elem.Type()},
+		value: elem,
+	}
+	if err := setFieldDataHelper(field, value); err != nil {
+		return fmt.Errorf("aconfig: cannot parse %q: %w", value, err)
+	}
+	return nil
+}
+
+func setFieldDataHelper(field *fieldData, value string) (err error) {
+	defer recoverFieldPanic(&err, field)
+
+	// unwrap pointers, the field keeps the pointer value, so it can be cleared later
+	if field.value.Type().Kind() == reflect.Ptr {
+		elem := *field
+		for elem.value.Type().Kind() == reflect.Ptr {
+			if elem.value.IsNil() {
+				elem.value.Set(reflect.New(elem.value.Type().Elem()))
+			}
+			elem.value = elem.value.Elem()
 		}
-		field.value = field.value.Elem()
+		field = &elem
 	}
 
 	if value == "" {
 		return nil
 	}
 
+	if format := inlineFormat(field.field); format != "" && isInlineKind(field.value.Kind()) {
+		return setInline(field, format, value)
+	}
+	if isByteSize(field) && isIntegerKind(field.value.Kind()) {
+		return setByteSize(field, value)
+	}
+	if isPercent(field) && isFloatKind(field.value.Kind()) {
+		return setPercent(field, value)
+	}
+
 	switch kind := field.value.Type().Kind(); kind {
 	case reflect.Bool:
 		return setBool(field, value)
@@ -458,6 +2700,19 @@ func setFieldDataHelper(field *fieldData, value string) error {
 	case reflect.Map:
 		return setMap(field, value)
 
+	case reflect.Struct:
+		if field.value.Type() == timeType {
+			return setTime(field, value)
+		}
+		if isEnvKV(field.field) {
+			return setStructFromKV(field, value)
+		}
+		return fmt.Errorf("type kind %q isn't supported", kind)
+
+	case reflect.Interface:
+		return fmt.Errorf("field %q has interface type %s, register implementations with RegisterImpl and select one with %q tag",
+			field.name, field.value.Type(), implTag)
+
 	default:
 		return fmt.Errorf("type kind %q isn't supported", kind)
 	}
@@ -475,17 +2730,17 @@ func setBool(field *fieldData, value string) error {
 func setInt(field *fieldData, value string) error {
 	val, err := strconv.ParseInt(value, 0, field.value.Type().Bits())
 	if err != nil {
-		return err
+		return numberError(field, value, err)
 	}
 	field.value.SetInt(val)
 	return nil
 }
 
 func setInt64(field *fieldData, value string) error {
-	if field.field.Type == reflect.TypeOf(time.Second) {
+	if field.value.Type() == durationType {
 		val, err := time.ParseDuration(value)
 		if err != nil {
-			return err
+			return fmt.Errorf("cannot parse %q as duration, want a value like 1.5h or -500ms", value)
 		}
 		field.value.Set(reflect.ValueOf(val))
 		return nil
@@ -493,67 +2748,155 @@ func setInt64(field *fieldData, value string) error {
 	return setInt(field, value)
 }
 
+// setTime sets a time from a value in one of timeLayouts or from a Unix timestamp.
+// A value of digits only (with an optional sign) is a timestamp: in milliseconds if its absolute value
+// is at least 1e11 (that's year 5138 in seconds), in seconds otherwise.
+// Times without a zone are in the location of `timezone:"Europe/Berlin"` tag, UTC by default,
+// timestamps are converted to that location too.
+func setTime(field *fieldData, value string) error {
+	loc := time.UTC
+	if name := field.field.Tag.Get(timezoneTag); name != "" {
+		var err error
+		if loc, err = time.LoadLocation(name); err != nil {
+			return fmt.Errorf("incorrect %s tag of field %q: %w", timezoneTag, field.name, err)
+		}
+	}
+
+	if isUnixTimestamp(value) {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("cannot parse %q as Unix timestamp: %w", value, err)
+		}
+		val := time.Unix(n, 0)
+		if n >= 1e11 || n <= -1e11 {
+			val = time.Unix(n/1000, n%1000*int64(time.Millisecond))
+		}
+		field.value.Set(reflect.ValueOf(val.In(loc)))
+		return nil
+	}
+
+	var err error
+	for _, layout := range timeLayouts {
+		var val time.Time
+		val, err = time.ParseInLocation(layout, value, loc)
+		if err != nil {
+			continue
+		}
+		if name, offset := val.Zone(); name == "UTC" && offset == 0 {
+			val = val.UTC()
+		}
+		field.value.Set(reflect.ValueOf(val))
+		return nil
+	}
+	return err
+}
+
+func isUnixTimestamp(value string) bool {
+	digits := strings.TrimLeft(value, "+-")
+	if digits == "" || len(value)-len(digits) > 1 {
+		return false
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 func setUint(field *fieldData, value string) error {
 	val, err := strconv.ParseUint(value, 0, field.value.Type().Bits())
 	if err != nil {
-		return err
+		return numberError(field, value, err)
 	}
 	field.value.SetUint(val)
 	return nil
 }
 
+// setFloat sets a float, Inf and NaN are accepted only with `allow_inf:"true"` tag.
 func setFloat(field *fieldData, value string) error {
 	val, err := strconv.ParseFloat(value, field.value.Type().Bits())
 	if err != nil {
-		return err
+		return numberError(field, value, err)
+	}
+	if (math.IsInf(val, 0) || math.IsNaN(val)) && field.field.Tag.Get(allowInfTag) != "true" {
+		return fmt.Errorf("cannot parse %q as %s: Inf and NaN aren't allowed without %s tag", value, field.value.Kind(), allowInfTag)
 	}
 	field.value.SetFloat(val)
 	return nil
 }
 
+// numberError replaces raw strconv error with a readable one.
+func numberError(field *fieldData, value string, err error) error {
+	var numErr *strconv.NumError
+	if errors.As(err, &numErr) {
+		err = numErr.Err
+	}
+	return fmt.Errorf("cannot parse %q as %s: %w", value, field.value.Kind(), err)
+}
+
 func setString(field *fieldData, value string) error {
 	field.value.SetString(value)
 	return nil
 }
 
+// setSlice replaces the slice with the given items,
+// for a field with `merge:"true"` tag the items are appended.
 func setSlice(field *fieldData, value string) error {
 	vals := strings.Split(value, ",")
-	slice := reflect.MakeSlice(field.field.Type, len(vals), len(vals))
+	slice := reflect.MakeSlice(field.value.Type(), len(vals), len(vals))
 	for i, val := range vals {
 		val = strings.TrimSpace(val)
 
-		fd := newFieldData(reflect.StructField{}, slice.Index(i), nil)
+		fd := newElemFieldData(field, slice.Index(i))
 		if err := setFieldDataHelper(fd, val); err != nil {
 			return fmt.Errorf("incorrect slice item %q: %w", val, err)
 		}
 	}
+	if field.merge && !field.value.IsNil() {
+		slice = reflect.AppendSlice(field.value, slice)
+	}
 	field.value.Set(slice)
 	return nil
 }
 
+// setMap replaces the map with the given entries,
+// for a field with `merge:"true"` tag the entries are added to the map.
 func setMap(field *fieldData, value string) error {
 	vals := strings.Split(value, ",")
-	mapField := reflect.MakeMapWithSize(field.field.Type, len(vals))
+	mapField := reflect.MakeMapWithSize(field.value.Type(), len(vals))
+	if field.merge && !field.value.IsNil() {
+		mapField = field.value
+	}
 
+	mapData := &fieldData{name: field.name, field: field.field, value: mapField}
 	for _, val := range vals {
 		entry := strings.SplitN(val, ":", 2)
 		if len(entry) != 2 {
 			return fmt.Errorf("incorrect map item: %s", val)
 		}
-		key := strings.TrimSpace(entry[0])
-		val := strings.TrimSpace(entry[1])
-
-		fdk := newSimpleFieldData(reflect.New(field.field.Type.Key()).Elem())
-		if err := setFieldDataHelper(fdk, key); err != nil {
-			return fmt.Errorf("incorrect map key %q: %w", key, err)
-		}
-
-		fdv := newSimpleFieldData(reflect.New(field.field.Type.Elem()).Elem())
-		if err := setFieldDataHelper(fdv, val); err != nil {
-			return fmt.Errorf("incorrect map value %q: %w", val, err)
+		if err := setMapEntry(mapData, strings.TrimSpace(entry[0]), strings.TrimSpace(entry[1])); err != nil {
+			return err
 		}
-		mapField.SetMapIndex(fdk.value, fdv.value)
 	}
 	field.value.Set(mapField)
 	return nil
 }
+
+func setMapEntry(field *fieldData, key, val string) error {
+	mapType := field.value.Type()
+
+	mapKey := reflect.New(mapType.Key()).Elem()
+	fdk := newElemFieldData(field, mapKey)
+	if err := setFieldDataHelper(fdk, key); err != nil {
+		return fmt.Errorf("incorrect map key %q: %w", key, err)
+	}
+
+	mapValue := reflect.New(mapType.Elem()).Elem()
+	fdv := newElemFieldData(field, mapValue)
+	if err := setFieldDataHelper(fdv, val); err != nil {
+		return fmt.Errorf("incorrect map value %q: %w", val, err)
+	}
+	field.value.SetMapIndex(mapKey, mapValue)
+	return nil
+}
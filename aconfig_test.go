@@ -1,11 +1,21 @@
 package aconfig
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -131,6 +141,121 @@ func TestLoadDefault_DurationConfig(t *testing.T) {
 	}
 }
 
+func TestLoadDefault_SliceTypes(t *testing.T) {
+	type SliceConfig struct {
+		Durs    []time.Duration  `default:"1s,2m,-3h"`
+		PtrDurs []*time.Duration `default:"1ms"`
+		Times   []time.Time      `default:"2000-04-05T10:20:30Z,2001-02-03"`
+		PtrInts *[]int           `default:"1,2,3"`
+	}
+
+	loader := LoaderFor(&SliceConfig{}).
+		SkipFiles().
+		SkipEnvironment().
+		SkipFlags().
+		Build()
+
+	var cfg SliceConfig
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []time.Duration{time.Second, 2 * time.Minute, -3 * time.Hour}; !reflect.DeepEqual(cfg.Durs, want) {
+		t.Fatalf("want %v, got %v", want, cfg.Durs)
+	}
+	if len(cfg.PtrDurs) != 1 || *cfg.PtrDurs[0] != time.Millisecond {
+		t.Fatalf("want %v, got %v", time.Millisecond, cfg.PtrDurs)
+	}
+	wantTimes := []time.Time{
+		time.Date(2000, 4, 5, 10, 20, 30, 0, time.UTC),
+		time.Date(2001, 2, 3, 0, 0, 0, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(cfg.Times, wantTimes) {
+		t.Fatalf("want %v, got %v", wantTimes, cfg.Times)
+	}
+	if want := []int{1, 2, 3}; cfg.PtrInts == nil || !reflect.DeepEqual(*cfg.PtrInts, want) {
+		t.Fatalf("want %v, got %v", want, cfg.PtrInts)
+	}
+}
+
+func TestLoadDefault_MapTypes(t *testing.T) {
+	type MapConfig struct {
+		Durs    map[string]time.Duration `default:"a:1s,b:2m"`
+		ByDur   map[time.Duration]string `default:"1h:hour,1m:minute"`
+		Times   map[string]time.Time     `default:"start:2000-04-05T10:20:30Z"`
+		PtrMap  *map[string]int          `default:"x:1"`
+		PtrVals map[string]*int          `default:"y:2"`
+	}
+
+	loader := LoaderFor(&MapConfig{}).
+		SkipFiles().
+		SkipEnvironment().
+		SkipFlags().
+		Build()
+
+	var cfg MapConfig
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := map[string]time.Duration{"a": time.Second, "b": 2 * time.Minute}; !reflect.DeepEqual(cfg.Durs, want) {
+		t.Fatalf("want %v, got %v", want, cfg.Durs)
+	}
+	if want := map[time.Duration]string{time.Hour: "hour", time.Minute: "minute"}; !reflect.DeepEqual(cfg.ByDur, want) {
+		t.Fatalf("want %v, got %v", want, cfg.ByDur)
+	}
+	if want := time.Date(2000, 4, 5, 10, 20, 30, 0, time.UTC); !cfg.Times["start"].Equal(want) {
+		t.Fatalf("want %v, got %v", want, cfg.Times)
+	}
+	if want := map[string]int{"x": 1}; cfg.PtrMap == nil || !reflect.DeepEqual(*cfg.PtrMap, want) {
+		t.Fatalf("want %v, got %v", want, cfg.PtrMap)
+	}
+	if cfg.PtrVals["y"] == nil || *cfg.PtrVals["y"] != 2 {
+		t.Fatalf("want %v, got %v", 2, cfg.PtrVals)
+	}
+}
+
+func TestLoadDefault_JSON(t *testing.T) {
+	type Server struct {
+		Host string `json:"host"`
+	}
+	type JSONConfig struct {
+		Ints    []int             `default:"[1, 2, 3]"`
+		Strs    []string          `default:"a,b"`
+		Map     map[string]int    `default:"{\"a\": 1, \"b\": 2}"`
+		Servers []Server          `default:"[{\"host\": \"a\"}]"`
+		Nested  map[string][]int  `default:"{\"x\": [1, 2]}"`
+		Ptr     *[]string         `default:"[\"p\"]"`
+		Main    Server            `default:"{\"host\": \"main\"}" env_format:"json"`
+		Colon   map[string]string `default:"a:b"`
+	}
+
+	loader := LoaderFor(&JSONConfig{}).
+		SkipFiles().
+		SkipEnvironment().
+		SkipFlags().
+		Build()
+
+	var cfg JSONConfig
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	want := JSONConfig{
+		Ints:    []int{1, 2, 3},
+		Strs:    []string{"a", "b"},
+		Map:     map[string]int{"a": 1, "b": 2},
+		Servers: []Server{{Host: "a"}},
+		Nested:  map[string][]int{"x": {1, 2}},
+		Ptr:     &[]string{"p"},
+		Main:    Server{Host: "main"},
+		Colon:   map[string]string{"a": "b"},
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("want %+v, got %+v", want, cfg)
+	}
+}
+
 func TestLoadDefault_OtherNumbersConfig(t *testing.T) {
 	type OtherNumbersConfig struct {
 		Int    int   `default:"0b111"`
@@ -163,145 +288,134 @@ func TestLoadDefault_OtherNumbersConfig(t *testing.T) {
 	}
 }
 
-func TestLoadFile(t *testing.T) {
-	f := func(filepath string) {
+func TestLoadDefault_Profile(t *testing.T) {
+	type ProfileConfig struct {
+		Host string `default:"localhost" default_prod:"db.internal"`
+		Port int    `default:"5432"`
+		Pass string `default:"pass" default_prod:""`
+	}
+
+	f := func(profile string, want ProfileConfig) {
 		t.Helper()
 
-		loader := LoaderFor(&TestConfig{}).
-			SkipDefaults().
+		loader := LoaderFor(&ProfileConfig{}).
+			SkipFiles().
 			SkipEnvironment().
 			SkipFlags().
-			WithFiles([]string{filepath}).
+			WithProfile(profile).
 			Build()
 
-		var cfg, want TestConfig
+		var cfg ProfileConfig
 		if err := loader.Load(&cfg); err != nil {
 			t.Fatal(err)
 		}
-
-		loadFile(t, filepath, &want)
-
-		if got := cfg; !reflect.DeepEqual(got, want) {
+		if got := cfg; got != want {
 			t.Fatalf("want %v, got %v", want, got)
 		}
 	}
 
-	f("testdata/config1.json")
-	f("testdata/config1.yaml")
-	f("testdata/config1.toml")
+	f("", ProfileConfig{Host: "localhost", Port: 5432, Pass: "pass"})
+	f("dev", ProfileConfig{Host: "localhost", Port: 5432, Pass: "pass"})
+	f("prod", ProfileConfig{Host: "db.internal", Port: 5432})
 }
 
-func TestLoadFile_WithFiles(t *testing.T) {
-	f := func(filepath string) {
+func TestLoadDefault_CustomTag(t *testing.T) {
+	type Config struct {
+		Host string `default:"other-lib" def:"localhost" def_prod:"db.internal"`
+		Port int    `default:"1" def:"5432"`
+		User string `default:"other-lib"`
+	}
+
+	f := func(profile string, want Config) {
 		t.Helper()
 
-		loader := LoaderFor(&TestConfig{}).
-			SkipDefaults().
+		loader := LoaderFor(&Config{}).
+			SkipFiles().
 			SkipEnvironment().
 			SkipFlags().
+			WithDefaultTag("def").
+			WithProfile(profile).
 			Build()
 
-		var cfg, want TestConfig
-		if err := loader.LoadWithFile(&cfg, filepath); err != nil {
+		var cfg Config
+		if err := loader.Load(&cfg); err != nil {
 			t.Fatal(err)
 		}
-
-		loadFile(t, filepath, &want)
-
-		if got := cfg; !reflect.DeepEqual(got, want) {
+		if got := cfg; got != want {
 			t.Fatalf("want %v, got %v", want, got)
 		}
 	}
 
-	f("testdata/config1.json")
-	f("testdata/config1.yaml")
-	f("testdata/config1.toml")
+	f("", Config{Host: "localhost", Port: 5432})
+	f("prod", Config{Host: "db.internal", Port: 5432})
 }
 
-func TestLoadEnv(t *testing.T) {
-	setEnv(t, "TST_STR", "str-env")
-	setEnv(t, "TST_INT", "121")
-	setEnv(t, "TST_HTTPPORT", "3000")
-	setEnv(t, "TST_SUB_FLOAT", "222.333")
-	setEnv(t, "TST_ANON_ISANON", "true")
-	setEnv(t, "TST_EM", "em-env")
-	defer os.Clearenv()
+func TestLoadDefault_Templates(t *testing.T) {
+	type TemplateConfig struct {
+		Host string `default:"localhost"`
+		Port int    `default:"8080"`
+		Addr string `default:"${Host}:${Port}"`
+		URL  string `default:"http://${Addr}/${Sub.Path}"`
+		Sub  struct {
+			Path string `default:"api"`
+		}
+	}
 
-	loader := LoaderFor(&TestConfig{}).
-		SkipDefaults().
+	loader := LoaderFor(&TemplateConfig{}).
 		SkipFiles().
 		SkipFlags().
-		WithEnvPrefix("tst").
+		WithEnvPrefix("TST").
 		Build()
 
-	var cfg TestConfig
+	setEnv(t, "TST_PORT", "9090")
+	defer os.Clearenv()
+
+	var cfg TemplateConfig
 	if err := loader.Load(&cfg); err != nil {
 		t.Fatal(err)
 	}
 
-	var want TestConfig
-	loadFile(t, "testdata/test_config_env.json", &want)
-
-	if got := cfg; !reflect.DeepEqual(got, want) {
-		t.Fatalf("want %v, got %v", want, got)
+	if want := "localhost:9090"; cfg.Addr != want {
+		t.Fatalf("want %v, got %v", want, cfg.Addr)
 	}
-}
-
-func TestLoadFlag(t *testing.T) {
-	loader := LoaderFor(&TestConfig{}).
-		SkipDefaults().
-		SkipFiles().
-		SkipEnvironment().
-		WithFlagPrefix("tst").
-		Build()
-
-	flags := []string{
-		"-tst.str=str-flag",
-		"-tst.int=1001",
-		"-tst.int=1001",
-		"-tst.httpport=30000",
-		"-tst.sub.float=123.321",
-		"-tst.anon.isanon=true",
-		"-tst.em=em-flag",
+	if want := "http://localhost:9090/api"; cfg.URL != want {
+		t.Fatalf("want %v, got %v", want, cfg.URL)
 	}
 
-	// hack for test :(
-	if err := loader.Flags().Parse(flags); err != nil {
-		t.Fatal(err)
-	}
+	setEnv(t, "TST_ADDR", "example.com:80")
 
-	var cfg TestConfig
-	if err := loader.Load(&cfg); err != nil {
+	var cfg2 TemplateConfig
+	if err := loader.Load(&cfg2); err != nil {
 		t.Fatal(err)
 	}
-
-	var want TestConfig
-	loadFile(t, "testdata/test_config_flag.json", &want)
-
-	if got := cfg; !reflect.DeepEqual(got, want) {
-		t.Fatalf("want %v, got %v", want, got)
+	if want := "example.com:80"; cfg2.Addr != want {
+		t.Fatalf("want %v, got %v", want, cfg2.Addr)
+	}
+	if want := "http://example.com:80/api"; cfg2.URL != want {
+		t.Fatalf("want %v, got %v", want, cfg2.URL)
 	}
-}
-
-func TestUsage(t *testing.T) {
-	loader := LoaderFor(&EmbeddedConfig{}).Build()
-
-	var builder strings.Builder
-	flags := loader.Flags()
-	flags.SetOutput(&builder)
-	flags.PrintDefaults()
 
-	got := builder.String()
-	want := `  -em string
-    	use... em...field. (default "em-def")
-`
+	setEnv(t, "TST_ADDR", "")
+	loader = LoaderFor(&TemplateConfig{}).
+		SkipFiles().
+		SkipFlags().
+		WithEnvPrefix("TST").
+		EmptyEnvClears().
+		Build()
 
-	if got != want {
-		t.Fatalf("want %v, got %v", want, got)
+	var cfg3 TemplateConfig
+	if err := loader.Load(&cfg3); err != nil {
+		t.Fatal(err)
+	}
+	if cfg3.Addr != "" {
+		t.Fatalf("want empty, got %v", cfg3.Addr)
+	}
+	if got := loader.SourceOf("Addr"); got != SourceEnv {
+		t.Fatalf("want %v, got %v", SourceEnv, got)
 	}
 }
 
-func TestBadDefauts(t *testing.T) {
+func TestLoadDefault_BadTemplates(t *testing.T) {
 	f := func(cfg interface{}) {
 		t.Helper()
 
@@ -317,359 +431,4892 @@ func TestBadDefauts(t *testing.T) {
 	}
 
 	f(&struct {
-		Bool bool `default:"omg"`
-	}{})
-
-	f(&struct {
-		Bool bool `default:"omg"`
+		A string `default:"${B}"`
+		B string `default:"${A}"`
 	}{})
 
 	f(&struct {
-		Int int `default:"1a"`
+		A string `default:"${A}"`
 	}{})
 
 	f(&struct {
-		Int8 int8 `default:"12a"`
+		A string `default:"${Unknown}"`
 	}{})
 
 	f(&struct {
-		Int16 int16 `default:"123a"`
+		A int    `default:"${B}"`
+		B string `default:"abc"`
 	}{})
+}
 
-	f(&struct {
-		Int32 int32 `default:"13a"`
-	}{})
+func TestMerge(t *testing.T) {
+	type MergeConfig struct {
+		Labels   map[string]string `json:"labels" merge:"true"`
+		Features []string          `json:"features" merge:"true"`
+		Replaced []string          `json:"replaced"`
+	}
 
-	f(&struct {
-		Int64 int64 `default:"23a"`
-	}{})
+	os.Clearenv()
+	setEnv(t, "TST_LABELS", "env:prod")
+	setEnv(t, "TST_LABELS_TEAM", "payments")
+	setEnv(t, "TST_FEATURES", "c")
+	setEnv(t, "TST_REPLACED", "c")
+	defer os.Clearenv()
 
-	f(&struct {
-		Uint uint `default:"1234a"`
-	}{})
+	var cfg MergeConfig
+	loader := LoaderFor(&cfg).
+		SkipFlags().
+		WithEnvPrefix("TST").
+		FailOnUnknownEnv().
+		Build()
 
-	f(&struct {
-		Uint8 uint8 `default:"124a"`
-	}{})
+	data := `{"labels": {"app": "api", "env": "dev"}, "features": ["a", "b"], "replaced": ["a", "b"]}`
+	if err := loader.LoadBytes(&cfg, []byte(data), "json"); err != nil {
+		t.Fatal(err)
+	}
 
-	f(&struct {
-		Uint16 uint16 `default:"134a"`
-	}{})
+	want := MergeConfig{
+		Labels:   map[string]string{"app": "api", "env": "prod", "team": "payments"},
+		Features: []string{"a", "b", "c"},
+		Replaced: []string{"c"},
+	}
+	if !reflect.DeepEqual(want, cfg) {
+		t.Fatalf("want %+v, got %+v", want, cfg)
+	}
+	if got := loader.SourceOf("Labels"); got != SourceEnv {
+		t.Fatalf("want %v, got %v", SourceEnv, got)
+	}
+}
 
-	f(&struct {
-		Uint32 uint32 `default:"234a"`
-	}{})
+func TestMerge_RepeatedLoad(t *testing.T) {
+	type MergeConfig struct {
+		Labels   map[string]string `json:"labels" merge:"true"`
+		Features []string          `default:"a" json:"features" merge:"true"`
+	}
 
-	f(&struct {
-		Uint64 uint64 `default:"24a"`
-	}{})
+	os.Clearenv()
+	setEnv(t, "TST_LABELS_TEAM", "payments")
+	setEnv(t, "TST_FEATURES", "c")
+	defer os.Clearenv()
 
-	f(&struct {
-		Float32 float32 `default:"1234x213"`
-	}{})
+	var cfg MergeConfig
+	loader := LoaderFor(&cfg).
+		SkipFlags().
+		WithEnvPrefix("TST").
+		Build()
 
-	f(&struct {
-		Float64 float64 `default:"1234x234"`
-	}{})
+	data := `{"labels": {"app": "api"}}`
+	for i := 0; i < 2; i++ {
+		if err := loader.LoadBytes(&cfg, []byte(data), "json"); err != nil {
+			t.Fatal(err)
+		}
+	}
 
-	f(&struct {
-		Dur time.Duration `default:"1h_2m3s"`
-	}{})
+	want := MergeConfig{
+		Labels:   map[string]string{"app": "api", "team": "payments"},
+		Features: []string{"a", "c"},
+	}
+	if !reflect.DeepEqual(want, cfg) {
+		t.Fatalf("want %+v, got %+v", want, cfg)
+	}
+}
 
-	f(&struct {
-		Slice []int `default:"1,a,2"`
-	}{})
+func TestMerge_EnvEntriesWithoutFile(t *testing.T) {
+	type MergeConfig struct {
+		Ports map[string]int `merge:"true"`
+		Other map[string]int
+	}
 
-	f(&struct {
-		Map map[string]int `default:"1:a,2:2"`
-	}{})
+	os.Clearenv()
+	setEnv(t, "TST_PORTS_HTTP", "80")
+	setEnv(t, "TST_OTHER_HTTP", "80")
+	defer os.Clearenv()
 
-	f(&struct {
-		Map map[int]string `default:"a:1"`
-	}{})
+	var cfg MergeConfig
+	loader := LoaderFor(&cfg).
+		SkipFiles().
+		SkipFlags().
+		WithEnvPrefix("TST").
+		Build()
 
-	f(&struct {
-		Map map[int]string `default:"a1"`
-	}{})
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := MergeConfig{Ports: map[string]int{"http": 80}}
+	if !reflect.DeepEqual(want, cfg) {
+		t.Fatalf("want %+v, got %+v", want, cfg)
+	}
 
-	f(&struct {
-		Array [2]string `default:"a1"`
-	}{})
+	setEnv(t, "TST_PORTS_HTTP", "abc")
+	if err := loader.Load(&MergeConfig{}); err == nil {
+		t.Fatal("must be an error")
+	}
 }
 
-func TestNotParsedFlags(t *testing.T) {
-	loader := LoaderFor(&TestConfig{}).
-		FailOnNotParsedFlags().
+func TestDefaultsAsFallback(t *testing.T) {
+	type FallbackConfig struct {
+		Host     string   `default:"localhost" json:"host"`
+		Port     int      `default:"8080" json:"port"`
+		Features []string `default:"a,b" json:"features" merge:"true"`
+		Timeout  string   `default:"${Host}:${Port}" json:"timeout"`
+	}
+
+	os.Clearenv()
+	setEnv(t, "TST_PORT", "9000")
+	defer os.Clearenv()
+
+	var cfg FallbackConfig
+	loader := LoaderFor(&cfg).
+		SkipFlags().
+		WithEnvPrefix("TST").
+		DefaultsAsFallback().
 		Build()
 
-	var cfg TestConfig
-	if err := loader.Load(&cfg); err == nil {
+	data := `{"features": ["c"]}`
+	if err := loader.LoadBytes(&cfg, []byte(data), "json"); err != nil {
 		t.Fatal(err)
 	}
-}
 
-func TestBadFiles(t *testing.T) {
+	want := FallbackConfig{
+		Host:     "localhost",
+		Port:     9000,
+		Features: []string{"c"},
+		Timeout:  "localhost:9000",
+	}
+	if !reflect.DeepEqual(want, cfg) {
+		t.Fatalf("want %+v, got %+v", want, cfg)
+	}
+	if got := loader.SourceOf("Host"); got != SourceDefault {
+		t.Fatalf("want %v, got %v", SourceDefault, got)
+	}
+	if got := loader.SourceOf("Port"); got != SourceEnv {
+		t.Fatalf("want %v, got %v", SourceEnv, got)
+	}
+}
+
+type DefaulterConfig struct {
+	Name   string `default:"tag"`
+	Port   int
+	Tags   []string
+	Nested DefaulterNested
+	Ptr    *DefaulterNested
+}
+
+func (c *DefaulterConfig) SetDefaults() {
+	c.Name = "code"
+	c.Port = 8080
+	c.Tags = []string{"a", "b"}
+	c.Nested.Level = "parent"
+	c.Nested.Limit = 1
+}
+
+type DefaulterNested struct {
+	Level  string
+	Limit  int
+	Labels map[string]string
+}
+
+func (n *DefaulterNested) SetDefaults() {
+	n.Level = "nested"
+	n.Labels = map[string]string{"team": "core"}
+}
+
+func TestLoadDefault_Defaulter(t *testing.T) {
+	os.Clearenv()
+	setEnv(t, "TST_PORT", "9090")
+	defer os.Clearenv()
+
+	var cfg DefaulterConfig
+	loader := LoaderFor(&cfg).
+		SkipFiles().
+		SkipFlags().
+		WithEnvPrefix("TST").
+		Build()
+
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	want := DefaulterConfig{
+		Name: "tag",
+		Port: 9090,
+		Tags: []string{"a", "b"},
+		Nested: DefaulterNested{
+			Level:  "nested",
+			Limit:  1,
+			Labels: map[string]string{"team": "core"},
+		},
+	}
+	if !reflect.DeepEqual(want, cfg) {
+		t.Fatalf("want %+v, got %+v", want, cfg)
+	}
+
+	cfg = DefaulterConfig{}
+	if err := loader.LoadWith(&cfg, WithoutDefaults()); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Name != "" || cfg.Nested.Level != "" {
+		t.Fatalf("defaults must be skipped, got %+v", cfg)
+	}
+}
+
+func TestLoadDefault_Conditional(t *testing.T) {
+	type ConditionalConfig struct {
+		CacheEnabled bool   `default:"true"`
+		CacheSize    int    `default_if:"CacheEnabled=true:1000"`
+		CacheTTL     string `default_if:"CacheSize=1000:1m"`
+		Mode         string `default:"fast"`
+		Workers      int    `default_if:"Mode=slow:1"`
+	}
+
+	f := func(env map[string]string, want ConditionalConfig) *Loader {
+		t.Helper()
+
+		os.Clearenv()
+		for k, v := range env {
+			setEnv(t, k, v)
+		}
+
+		loader := LoaderFor(&ConditionalConfig{}).
+			SkipFiles().
+			SkipFlags().
+			WithEnvPrefix("TST").
+			Build()
+
+		var cfg ConditionalConfig
+		if err := loader.Load(&cfg); err != nil {
+			t.Fatal(err)
+		}
+		if got := cfg; got != want {
+			t.Fatalf("want %+v, got %+v", want, got)
+		}
+		return loader
+	}
+	defer os.Clearenv()
+
+	f(nil, ConditionalConfig{CacheEnabled: true, CacheSize: 1000, CacheTTL: "1m", Mode: "fast"})
+	f(map[string]string{"TST_CACHEENABLED": "false"}, ConditionalConfig{Mode: "fast"})
+	f(map[string]string{"TST_CACHESIZE": "5"}, ConditionalConfig{CacheEnabled: true, CacheSize: 5, Mode: "fast"})
+	f(map[string]string{"TST_MODE": "slow"}, ConditionalConfig{CacheEnabled: true, CacheSize: 1000, CacheTTL: "1m", Mode: "slow", Workers: 1})
+
+	loader := f(map[string]string{"TST_CACHESIZE": "0"}, ConditionalConfig{CacheEnabled: true, Mode: "fast"})
+	if got := loader.SourceOf("CacheSize"); got != SourceEnv {
+		t.Fatalf("want %v, got %v", SourceEnv, got)
+	}
+}
+
+func TestLoadDefault_BadConditional(t *testing.T) {
+	f := func(cfg interface{}) {
+		t.Helper()
+
+		loader := LoaderFor(cfg).
+			SkipFiles().
+			SkipEnvironment().
+			SkipFlags().
+			Build()
+
+		if err := loader.Load(cfg); err == nil {
+			t.Fatal(err)
+		}
+	}
+
+	f(&struct {
+		A string `default_if:"B=:a"`
+		B string `default_if:"A=:b"`
+	}{})
+
+	f(&struct {
+		A string `default_if:"Unknown=1:a"`
+	}{})
+
+	f(&struct {
+		A string `default_if:"A"`
+	}{})
+
+	f(&struct {
+		A string `default_if:"B=1"`
+		B string
+	}{})
+
+	f(&struct {
+		A int `default_if:"B=:abc"`
+		B string
+	}{})
+}
+
+func TestLoadFile(t *testing.T) {
+	f := func(filepath string) {
+		t.Helper()
+
+		loader := LoaderFor(&TestConfig{}).
+			SkipDefaults().
+			SkipEnvironment().
+			SkipFlags().
+			WithFiles([]string{filepath}).
+			Build()
+
+		var cfg, want TestConfig
+		if err := loader.Load(&cfg); err != nil {
+			t.Fatal(err)
+		}
+
+		loadFile(t, filepath, &want)
+
+		if got := cfg; !reflect.DeepEqual(got, want) {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	}
+
+	f("testdata/config1.json")
+	f("testdata/config1.yaml")
+	f("testdata/config1.toml")
+}
+
+func TestLoadFile_Gzip(t *testing.T) {
+	f := func(filepath, want string) {
+		t.Helper()
+
+		loader := LoaderFor(&TestConfig{}).
+			SkipDefaults().
+			SkipEnvironment().
+			SkipFlags().
+			StopOnFileError().
+			WithFiles([]string{filepath}).
+			Build()
+
+		var cfg TestConfig
+		if err := loader.Load(&cfg); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Str != want {
+			t.Fatalf("want %v, got %v", want, cfg.Str)
+		}
+		if cfg.Sub.Float != 999.111 {
+			t.Fatalf("want %v, got %v", 999.111, cfg.Sub.Float)
+		}
+	}
+
+	f("testdata/config1.json.gz", "str-json")
+	f("testdata/remain_config.YAML.GZ", "str-json")
+}
+
+func TestLoadFile_URL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			_, _ = w.Write([]byte(`{"Str": "str-url-json"}`))
+		case "/config.yaml":
+			_, _ = w.Write([]byte(`str: "str-url-yaml"`))
+		case "/config.json.gz":
+			zw := gzip.NewWriter(w)
+			_, _ = zw.Write([]byte(`{"Str": "str-url-gzip"}`))
+			_ = zw.Close()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	f := func(path, want string) {
+		t.Helper()
+
+		loader := LoaderFor(&TestConfig{}).
+			SkipDefaults().
+			SkipEnvironment().
+			SkipFlags().
+			AllowURLs().
+			StopOnFileError().
+			WithFiles([]string{srv.URL + path}).
+			Build()
+
+		var cfg TestConfig
+		if err := loader.Load(&cfg); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Str != want {
+			t.Fatalf("want %v, got %v", want, cfg.Str)
+		}
+	}
+
+	f("/config", "str-url-json")
+	f("/config.yaml", "str-url-yaml")
+	f("/config.yaml?ref=main", "str-url-yaml")
+	f("/config.json.gz?x=1", "str-url-gzip")
+
+	loader := LoaderFor(&TestConfig{}).
+		SkipDefaults().
+		SkipEnvironment().
+		SkipFlags().
+		AllowURLs().
+		StopOnFileError().
+		WithFiles([]string{srv.URL + "/not-found.json"}).
+		Build()
+
+	var cfg TestConfig
+	if err := loader.Load(&cfg); err == nil {
+		t.Fatal("want error for not found URL")
+	}
+
+	// URLs are files without explicit permission
+	loader = LoaderFor(&TestConfig{}).
+		SkipDefaults().
+		SkipEnvironment().
+		SkipFlags().
+		WithFiles([]string{srv.URL + "/config.yaml"}).
+		Build()
+
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Str != "" {
+		t.Fatalf("want empty, got %v", cfg.Str)
+	}
+}
+
+func TestLoadFile_WithFiles(t *testing.T) {
+	f := func(filepath string) {
+		t.Helper()
+
+		loader := LoaderFor(&TestConfig{}).
+			SkipDefaults().
+			SkipEnvironment().
+			SkipFlags().
+			Build()
+
+		var cfg, want TestConfig
+		if err := loader.LoadWithFile(&cfg, filepath); err != nil {
+			t.Fatal(err)
+		}
+
+		loadFile(t, filepath, &want)
+
+		if got := cfg; !reflect.DeepEqual(got, want) {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	}
+
+	f("testdata/config1.json")
+	f("testdata/config1.yaml")
+	f("testdata/config1.toml")
+}
+
+func TestLoadFile_Remain(t *testing.T) {
+	type RemainSub struct {
+		Float  float64
+		Remain map[string]interface{} `aconfig:",remain"`
+	}
+	type RemainConfig struct {
+		Str    string
+		Sub    RemainSub
+		Remain map[string]interface{} `aconfig:",remain"`
+	}
+
+	f := func(filepath string) {
+		t.Helper()
+
+		loader := LoaderFor(&RemainConfig{}).
+			SkipDefaults().
+			SkipEnvironment().
+			SkipFlags().
+			WithFiles([]string{filepath}).
+			Build()
+
+		var cfg RemainConfig
+		if err := loader.Load(&cfg); err != nil {
+			t.Fatal(err)
+		}
+
+		if want := "str-json"; cfg.Str != want {
+			t.Fatalf("want %v, got %v", want, cfg.Str)
+		}
+		if want := 999.111; cfg.Sub.Float != want {
+			t.Fatalf("want %v, got %v", want, cfg.Sub.Float)
+		}
+		if len(cfg.Remain) != 2 {
+			t.Fatalf("want 2 remain keys, got %v", cfg.Remain)
+		}
+		for key, val := range cfg.Remain {
+			switch strings.ToLower(key) {
+			case "extra":
+				if val != "extra-json" {
+					t.Fatalf("want %v, got %v", "extra-json", val)
+				}
+			case "more":
+				if _, ok := val.(map[string]interface{}); !ok {
+					t.Fatalf("want map, got %T", val)
+				}
+			default:
+				t.Fatalf("unexpected remain key %v", key)
+			}
+		}
+		if len(cfg.Sub.Remain) != 1 {
+			t.Fatalf("want 1 remain key, got %v", cfg.Sub.Remain)
+		}
+	}
+
+	f("testdata/remain_config.json")
+	f("testdata/remain_config.yaml")
+	f("testdata/remain_config.toml")
+}
+
+func TestLoadFile_RemainKeyCase(t *testing.T) {
+	type RemainConfig struct {
+		Str    string
+		Remain map[string]interface{} `aconfig:",remain"`
+	}
+
+	f := func(data, ext string, want map[string]interface{}) {
+		t.Helper()
+
+		var cfg RemainConfig
+		loader := LoaderFor(&cfg).
+			SkipDefaults().
+			SkipEnvironment().
+			SkipFlags().
+			Build()
+
+		if err := loader.LoadBytes(&cfg, []byte(data), ext); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Str != "str" {
+			t.Fatalf("want %v, got %v", "str", cfg.Str)
+		}
+		if !reflect.DeepEqual(want, cfg.Remain) {
+			t.Fatalf("want %v, got %v", want, cfg.Remain)
+		}
+	}
+
+	// YAML decoder matches the keys exactly, so "Str" isn't set to the field
+	f("str: str\nStr: other\n", "yaml", map[string]interface{}{"Str": "other"})
+	f(`{"STR": "str", "extra": 1}`, "json", map[string]interface{}{"extra": float64(1)})
+	f("STR = \"str\"\nextra = 1\n", "toml", map[string]interface{}{"extra": int64(1)})
+}
+
+func TestLoadFile_TimeTypes(t *testing.T) {
+	type TimeConfig struct {
+		Timeout time.Duration
+		Started time.Time
+		Sub     struct {
+			Backoff *time.Duration
+		}
+		Other int
+	}
+
 	f := func(filepath string) {
 		t.Helper()
 
-		loader := LoaderFor(&TestConfig{}).
-			SkipDefaults().
-			SkipEnvironment().
+		loader := LoaderFor(&TimeConfig{}).
+			SkipDefaults().
+			SkipEnvironment().
+			SkipFlags().
+			StopOnFileError().
+			WithFiles([]string{filepath}).
+			Build()
+
+		var cfg TimeConfig
+		if err := loader.Load(&cfg); err != nil {
+			t.Fatal(err)
+		}
+
+		if want := 5 * time.Second; cfg.Timeout != want {
+			t.Fatalf("want %v, got %v", want, cfg.Timeout)
+		}
+		if want := time.Date(2000, 4, 5, 10, 20, 30, 0, time.UTC); !cfg.Started.Equal(want) {
+			t.Fatalf("want %v, got %v", want, cfg.Started)
+		}
+		if want := -90 * time.Minute; cfg.Sub.Backoff == nil || *cfg.Sub.Backoff != want {
+			t.Fatalf("want %v, got %v", want, cfg.Sub.Backoff)
+		}
+		if want := 42; cfg.Other != want {
+			t.Fatalf("want %v, got %v", want, cfg.Other)
+		}
+	}
+
+	f("testdata/time_config.json")
+	f("testdata/time_config.yaml")
+	f("testdata/time_config.toml")
+}
+
+func TestLoadFile_TimeElems(t *testing.T) {
+	type TimeElemsConfig struct {
+		List   []time.Duration          `json:"list" yaml:"list" toml:"list"`
+		Map    map[string]time.Duration `json:"m" yaml:"m" toml:"m"`
+		Times  []time.Time              `json:"times" yaml:"times" toml:"times"`
+		Nanos  []time.Duration          `json:"nanos" yaml:"nanos" toml:"nanos"`
+		Labels map[string]string        `json:"labels" yaml:"labels" toml:"labels"`
+	}
+
+	day := time.Date(2000, 4, 5, 0, 0, 0, 0, time.UTC)
+	unix := time.Unix(954930030, 0).UTC()
+
+	f := func(data, ext string, times ...time.Time) {
+		t.Helper()
+
+		var cfg TimeElemsConfig
+		loader := LoaderFor(&cfg).
+			SkipDefaults().
+			SkipEnvironment().
+			SkipFlags().
+			Build()
+
+		if err := loader.LoadBytes(&cfg, []byte(data), ext); err != nil {
+			t.Fatal(err)
+		}
+
+		want := TimeElemsConfig{
+			List:   []time.Duration{time.Second, 2 * time.Minute},
+			Map:    map[string]time.Duration{"a": 2 * time.Second},
+			Times:  times,
+			Nanos:  []time.Duration{1000},
+			Labels: map[string]string{"a": "b"},
+		}
+		if !reflect.DeepEqual(want, cfg) {
+			t.Fatalf("want %+v, got %+v", want, cfg)
+		}
+		if got := loader.SourceOf("List"); got != SourceFile {
+			t.Fatalf("want %v, got %v", SourceFile, got)
+		}
+	}
+
+	// numbers of durations are nanoseconds, they're decoded by the decoder
+	f(`{"list": ["1s", "2m"], "m": {"a": "2s"}, "times": ["2000-04-05", 954930030], "nanos": [1000], "labels": {"a": "b"}}`, "json", day, unix)
+	f("list: [1s, 2m]\nm: {a: 2s}\ntimes: [\"2000-04-05\", 954930030]\nnanos: [1000]\nlabels: {a: b}\n", "yaml", day, unix)
+	f("list = [\"1s\", \"2m\"]\ntimes = [\"2000-04-05\"]\nnanos = [1000]\n[m]\na = \"2s\"\n[labels]\na = \"b\"\n", "toml", day)
+}
+
+func TestLoadFile_KeepsValuesWhenReencoded(t *testing.T) {
+	type ReencodeConfig struct {
+		Timeout time.Duration     `json:"timeout" yaml:"timeout" toml:"timeout"`
+		ID      int64             `json:"id" yaml:"id" toml:"id"`
+		Codes   map[int]string    `json:"codes" yaml:"codes"`
+		Labels  map[string]string `json:"labels" yaml:"labels" toml:"labels"`
+	}
+
+	f := func(data, format string, caseInsensitive bool, want ReencodeConfig) {
+		t.Helper()
+
+		var cfg ReencodeConfig
+		loader := LoaderFor(&cfg).SkipDefaults().SkipEnvironment().SkipFlags()
+		if caseInsensitive {
+			loader = loader.CaseInsensitiveKeys()
+		}
+		loader.Build()
+
+		if err := loader.LoadBytes(&cfg, []byte(data), format); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(want, cfg) {
+			t.Fatalf("want %+v, got %+v", want, cfg)
+		}
+	}
+
+	// the duration makes the loader take the value and encode the rest of the file once more
+	want := ReencodeConfig{Timeout: 5 * time.Second, ID: 9007199254740993}
+	f(`{"timeout": "5s", "id": 9007199254740993}`, "json", false, want)
+	f(`{"timeout": "5s", "id": 9007199254740993}`, "json", true, want)
+	f("timeout: 5s\nid: 9007199254740993\n", "yaml", false, want)
+	f("timeout = \"5s\"\nid = 9007199254740993\n", "toml", false, want)
+
+	want = ReencodeConfig{Timeout: 5 * time.Second, Codes: map[int]string{1: "one", 2: "two"}}
+	f("timeout: 5s\ncodes:\n  1: one\n  2: two\n", "yaml", false, want)
+	f(`{"timeout": "5s", "codes": {"1": "one", "2": "two"}}`, "json", false, want)
+
+	want = ReencodeConfig{Timeout: 5 * time.Second, Labels: map[string]string{"team": "core"}}
+	f("timeout: 5s\nlabels:\n  team: core\n", "yaml", false, want)
+}
+
+func TestLoadFile_Empty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := func(file, data string) {
+		t.Helper()
+
+		file = filepath.Join(dir, file)
+		writeFile(t, file, data)
+
+		var cfg TestConfig
+		loader := LoaderFor(&cfg).
+			SkipEnvironment().
+			SkipFlags().
+			StopOnFileError().
+			WithFiles([]string{file, "testdata/config1.json"}).
+			Build()
+
+		if err := loader.Load(&cfg); err != nil {
+			t.Fatal(err)
+		}
+		// the empty file is loaded, so the next one isn't
+		if want := "str-def"; cfg.Str != want {
+			t.Fatalf("want %v, got %v", want, cfg.Str)
+		}
+	}
+
+	for _, ext := range []string{"json", "yaml", "toml"} {
+		f("empty."+ext, "")
+		f("blank."+ext, " \n\t\r\n")
+	}
+
+	var cfg TestConfig
+	loader := LoaderFor(&cfg).SkipEnvironment().SkipFlags().Build()
+	if err := loader.LoadBytes(&cfg, []byte("\n"), "json"); err != nil {
+		t.Fatal(err)
+	}
+	if want := "str-def"; cfg.Str != want {
+		t.Fatalf("want %v, got %v", want, cfg.Str)
+	}
+}
+
+func TestTimeZoneAndUnixTime(t *testing.T) {
+	type TimeConfig struct {
+		Local    time.Time `default:"2000-04-05 10:20:30" timezone:"Europe/Berlin"`
+		Zoned    time.Time `default:"2000-04-05T10:20:30Z" timezone:"Europe/Berlin"`
+		Seconds  time.Time `default:"954930030"`
+		Millis   time.Time `default:"954930030500"`
+		InZone   time.Time `default:"954930030" timezone:"Europe/Berlin"`
+		FromFile time.Time
+	}
+
+	dir, err := ioutil.TempDir("", "aconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	utc := time.Date(2000, 4, 5, 10, 20, 30, 0, time.UTC)
+
+	f := func(file, data string) {
+		t.Helper()
+
+		file = filepath.Join(dir, file)
+		writeFile(t, file, data)
+
+		var cfg TimeConfig
+		loader := LoaderFor(&cfg).
+			SkipEnvironment().
+			SkipFlags().
+			StopOnFileError().
+			WithFiles([]string{file}).
+			Build()
+
+		if err := loader.Load(&cfg); err != nil {
+			t.Fatal(err)
+		}
+
+		if want := time.Date(2000, 4, 5, 10, 20, 30, 0, berlin); !cfg.Local.Equal(want) {
+			t.Fatalf("want %v, got %v", want, cfg.Local)
+		}
+		if !cfg.Zoned.Equal(utc) {
+			t.Fatalf("want %v, got %v", utc, cfg.Zoned)
+		}
+		if !cfg.Seconds.Equal(utc) || cfg.Seconds.Location() != time.UTC {
+			t.Fatalf("want %v, got %v", utc, cfg.Seconds)
+		}
+		if want := utc.Add(500 * time.Millisecond); !cfg.Millis.Equal(want) {
+			t.Fatalf("want %v, got %v", want, cfg.Millis)
+		}
+		if !cfg.InZone.Equal(utc) || cfg.InZone.Location().String() != "Europe/Berlin" {
+			t.Fatalf("want %v in %v, got %v", utc, berlin, cfg.InZone)
+		}
+		if !cfg.FromFile.Equal(utc) {
+			t.Fatalf("want %v, got %v", utc, cfg.FromFile)
+		}
+	}
+
+	f("time.json", `{"FromFile": 954930030}`)
+	f("time.yaml", "fromfile: 954930030\n")
+	f("time.toml", "FromFile = 954930030\n")
+
+	type BadZone struct {
+		Start time.Time `default:"2000-04-05" timezone:"Mars/Olympus"`
+	}
+	var bad BadZone
+	err = LoaderFor(&bad).SkipFiles().SkipEnvironment().SkipFlags().Build().Load(&bad)
+	if err == nil || !strings.Contains(err.Error(), `incorrect timezone tag of field "Start"`) {
+		t.Fatalf("want timezone error, got %v", err)
+	}
+
+	type FarConfig struct {
+		Future time.Time `default:"99999999999999"`
+		Past   time.Time `default:"-99999999999999"`
+	}
+	var far FarConfig
+	if err := LoaderFor(&far).SkipFiles().SkipEnvironment().SkipFlags().Build().Load(&far); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Unix(99999999999, 999*int64(time.Millisecond)); !far.Future.Equal(want) || far.Future.Year() != 5138 {
+		t.Fatalf("want %v, got %v", want, far.Future)
+	}
+	if want := time.Unix(-99999999999, -999*int64(time.Millisecond)); !far.Past.Equal(want) {
+		t.Fatalf("want %v, got %v", want, far.Past)
+	}
+}
+
+func TestLoadStdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+
+	if _, err := w.WriteString("str: str-stdin\nint: 42\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	type StdinConfig struct {
+		Str string `yaml:"str"`
+		Int int    `yaml:"int"`
+	}
+
+	var cfg StdinConfig
+	loader := LoaderFor(&cfg).
+		SkipDefaults().
+		SkipEnvironment().
+		SkipFlags().
+		WithFiles([]string{"-"}).
+		WithStdinFormat("YAML").
+		Build()
+
+	// stdin is read once, so the second load gets the same content
+	for i := 0; i < 2; i++ {
+		cfg = StdinConfig{}
+		if err := loader.Load(&cfg); err != nil {
+			t.Fatal(err)
+		}
+		if want := (StdinConfig{Str: "str-stdin", Int: 42}); cfg != want {
+			t.Fatalf("want %+v, got %+v", want, cfg)
+		}
+	}
+}
+
+func TestLoadBytes(t *testing.T) {
+	type BytesConfig struct {
+		Str string `json:"str" yaml:"str" toml:"str"`
+		Int int    `json:"int" yaml:"int" toml:"int" default:"42"`
+	}
+
+	f := func(data, format string) {
+		t.Helper()
+
+		var cfg BytesConfig
+		loader := LoaderFor(&cfg).
+			SkipEnvironment().
+			SkipFlags().
+			WithFiles([]string{"testdata/not_exists.json"}).
+			Build()
+
+		if err := loader.LoadBytes(&cfg, []byte(data), format); err != nil {
+			t.Fatal(err)
+		}
+		want := BytesConfig{Str: "str-value", Int: 42}
+		if cfg != want {
+			t.Fatalf("want %+v, got %+v", want, cfg)
+		}
+	}
+
+	f(`{"str": "str-value"}`, "json")
+	f("str: str-value", ".yaml")
+	f("str: str-value", "YML")
+	f(`str = "str-value"`, "toml")
+}
+
+func TestLoadReader(t *testing.T) {
+	var cfg TestConfig
+	loader := LoaderFor(&cfg).
+		SkipDefaults().
+		SkipEnvironment().
+		SkipFlags().
+		WithDefaultFormat(".json").
+		Build()
+
+	data, err := ioutil.ReadFile("testdata/config1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loader.LoadReader(&cfg, bytes.NewReader(data), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	want := TestConfig{}
+	loadFile(t, "testdata/config1.json", &want)
+	if got := cfg; !reflect.DeepEqual(want, got) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestLoadBytes_Bad(t *testing.T) {
+	f := func(data, format string) {
+		t.Helper()
+
+		var cfg TestConfig
+		loader := LoaderFor(&cfg).
+			SkipDefaults().
+			SkipEnvironment().
+			SkipFlags().
+			Build()
+
+		if err := loader.LoadBytes(&cfg, []byte(data), format); err == nil {
+			t.Fatal("must be an error")
+		}
+	}
+
+	f(`{"str": "str-value"}`, "")
+	f(`{"str": "str-value"}`, "xml")
+	f(`{"str": `, "json")
+	f("str: [", "yaml")
+}
+
+func TestCaseInsensitiveKeys(t *testing.T) {
+	type KeysConfig struct {
+		Name    string            `yaml:"name" toml:"name" json:"name"`
+		Port    int               `yaml:"port" toml:"port" json:"port"`
+		Timeout time.Duration     `yaml:"timeout" toml:"timeout" json:"timeout"`
+		Tags    []string          `yaml:"tags" toml:"tags" json:"tags"`
+		Labels  map[string]string `yaml:"labels" toml:"labels" json:"labels"`
+		Version string            `yaml:"version" toml:"version" json:"version"`
+		HTTP    struct {
+			Addr string `yaml:"addr" toml:"addr" json:"addr"`
+			TLS  *struct {
+				Cert string `yaml:"cert" toml:"cert" json:"cert"`
+			} `yaml:"tls" toml:"tls" json:"tls"`
+		} `yaml:"http" toml:"http" json:"http"`
+	}
+
+	f := func(data, format string) {
+		t.Helper()
+
+		var cfg KeysConfig
+		loader := LoaderFor(&cfg).
+			SkipDefaults().
+			SkipEnvironment().
+			SkipFlags().
+			CaseInsensitiveKeys().
+			Build()
+
+		if err := loader.LoadBytes(&cfg, []byte(data), format); err != nil {
+			t.Fatal(err)
+		}
+
+		switch {
+		case cfg.Name != "app" || cfg.Port != 8080 || cfg.Timeout != 5*time.Second || cfg.Version != "1":
+			t.Fatalf("got %+v", cfg)
+		case !reflect.DeepEqual(cfg.Tags, []string{"a", "b"}):
+			t.Fatalf("got %+v", cfg.Tags)
+		case !reflect.DeepEqual(cfg.Labels, map[string]string{"Team": "core"}):
+			t.Fatalf("got %+v", cfg.Labels)
+		case cfg.HTTP.Addr != "localhost" || cfg.HTTP.TLS == nil || cfg.HTTP.TLS.Cert != "cert.pem":
+			t.Fatalf("got %+v", cfg.HTTP)
+		}
+		if got := loader.SourceOf("HTTP.TLS.Cert"); got != SourceFile {
+			t.Fatalf("want %v, got %v", SourceFile, got)
+		}
+	}
+
+	f(`
+NAME: app
+Port: 8080
+TimeOut: 5s
+Tags: [a, b]
+Labels:
+  Team: core
+Version: 1
+Http.Addr: localhost
+HTTP:
+  Tls:
+    CERT: cert.pem
+`, "yaml")
+
+	f(`{"Name": "app", "PORT": 8080, "timeOut": "5s", "TAGS": ["a", "b"], "Labels": {"Team": "core"},
+		"VERSION": "1", "http.addr": "localhost", "Http": {"TLS.Cert": "cert.pem"}}`, "json")
+
+	f(`
+Name = "app"
+PORT = 8080
+TimeOut = "5s"
+Tags = ["a", "b"]
+Version = 1
+"Http.Addr" = "localhost"
+
+[Labels]
+Team = "core"
+
+[HTTP.Tls]
+CERT = "cert.pem"
+`, "toml")
+}
+
+func TestCaseInsensitiveKeys_Bad(t *testing.T) {
+	type KeysConfig struct {
+		Port int
+	}
+
+	var cfg KeysConfig
+	loader := LoaderFor(&cfg).
+		SkipDefaults().
+		SkipEnvironment().
+		SkipFlags().
+		CaseInsensitiveKeys().
+		Build()
+
+	if err := loader.LoadBytes(&cfg, []byte(`{"PORT": [1]}`), "json"); err == nil {
+		t.Fatal("must be an error")
+	}
+}
+
+func TestDeprecated(t *testing.T) {
+	type DeprecatedConfig struct {
+		OldName string `deprecated:"use TST_NEW_NAME instead"`
+		NewName string
+		OldPort int `default:"80" deprecated:"use TST_NEW_PORT instead"`
+	}
+
+	os.Clearenv()
+	setEnv(t, "TST_OLDNAME", "old")
+	defer os.Clearenv()
+
+	var warnings []string
+	var cfg DeprecatedConfig
+	loader := LoaderFor(&cfg).
+		SkipFiles().
+		SkipFlags().
+		WithEnvPrefix("TST").
+		WithLogger(func(format string, args ...interface{}) {
+			warnings = append(warnings, fmt.Sprintf(format, args...))
+		}).
+		Build()
+
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.OldName != "old" || cfg.OldPort != 80 {
+		t.Fatalf("got %+v", cfg)
+	}
+
+	want := []string{`aconfig: field "OldName" set from env is deprecated: use TST_NEW_NAME instead`}
+	if !reflect.DeepEqual(want, warnings) {
+		t.Fatalf("want %v, got %v", want, warnings)
+	}
+}
+
+func TestNestingSeparator(t *testing.T) {
+	type NestingConfig struct {
+		DBMaxConns int `yaml:"db_max_conns"`
+		DB         struct {
+			MaxConns int    `yaml:"max_conns"`
+			Host     string `yaml:"host"`
+		} `yaml:"db"`
+	}
+
+	os.Clearenv()
+	setEnv(t, "TST_DB_MAX_CONNS", "1")
+	setEnv(t, "TST_DB__MAX_CONNS", "2")
+	defer os.Clearenv()
+
+	newLoader := func(cfg *NestingConfig, sep string) *Loader {
+		return LoaderFor(cfg).
+			WithFiles([]string{"testdata/not_exists.yaml"}).
+			UseFileTags().
+			WithEnvPrefix("TST").
+			WithNestingSeparator(sep).
+			Build()
+	}
+
+	var cfg NestingConfig
+	if err := newLoader(&cfg, "").Load(&cfg); err == nil {
+		t.Fatal("want duplicate env error with default separator")
+	}
+
+	loader := newLoader(&cfg, "__")
+	if err := loader.Flags().Parse([]string{"-db__host=localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DBMaxConns != 1 || cfg.DB.MaxConns != 2 || cfg.DB.Host != "localhost" {
+		t.Fatalf("got %+v", cfg)
+	}
+}
+
+func TestDuplicateNames(t *testing.T) {
+	f := func(cfg interface{}, want string) {
+		t.Helper()
+
+		loader := LoaderFor(cfg).
+			SkipFiles().
+			WithEnvPrefix("TST").
+			Build()
+
+		err := loader.Load(cfg)
+		if err == nil {
+			t.Fatal("must be an error")
+		}
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("want %q in %q", want, err.Error())
+		}
+	}
+
+	f(&struct {
+		HTTP struct {
+			Port int
+		}
+		HTTPPort int `env:"HTTP_PORT"`
+	}{}, `duplicate env name "TST_HTTP_PORT" of fields ["HTTP.Port" "HTTPPort"]`)
+
+	f(&struct {
+		Name string `flag:"name"`
+		User string `flag:"user" flag_aliases:"name"`
+	}{}, `duplicate flag name "name" of fields ["Name" "User"]`)
+
+	type Embedded struct {
+		Level int
+	}
+	f(&struct {
+		Embedded
+		Level string
+	}{}, `duplicate env name "TST_LEVEL" of fields ["Embedded.Level" "Level"]`)
+}
+
+func TestEnvPrefixes(t *testing.T) {
+	type PrefixesConfig struct {
+		Port    int
+		Host    string
+		Timeout int `env_aliases:"TTL"`
+	}
+
+	os.Clearenv()
+	setEnv(t, "MYAPP_PORT", "80")
+	setEnv(t, "OLDAPP_PORT", "8080")
+	setEnv(t, "OLDAPP_HOST", "old-host")
+	setEnv(t, "OLDAPP_TTL", "5")
+	defer os.Clearenv()
+
+	var cfg PrefixesConfig
+	loader := LoaderFor(&cfg).
+		SkipFiles().
+		SkipFlags().
+		WithEnvPrefixes("MYAPP", "OLDAPP").
+		FailOnUnknownEnv().
+		Build()
+
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	want := PrefixesConfig{Port: 80, Host: "old-host", Timeout: 5}
+	if cfg != want {
+		t.Fatalf("want %+v, got %+v", want, cfg)
+	}
+
+	setEnv(t, "OLDAPP_HOTS", "typo")
+	if err := loader.Load(&cfg); err == nil || !strings.Contains(err.Error(), `"OLDAPP_HOTS" (did you mean "OLDAPP_HOST"?)`) {
+		t.Fatalf("want unknown env error, got %v", err)
+	}
+}
+
+func TestWithEnv(t *testing.T) {
+	type EnvConfig struct {
+		Host   string
+		Port   int
+		Labels map[string]string `merge:"true"`
+	}
+
+	os.Clearenv()
+	setEnv(t, "TST_HOST", "process")
+	defer os.Clearenv()
+
+	var cfg EnvConfig
+	loader := LoaderFor(&cfg).
+		SkipFiles().
+		SkipFlags().
+		WithEnvPrefix("TST").
+		WithEnv(map[string]string{"TST_PORT": "80", "TST_LABELS_TEAM": "core"}).
+		FailOnUnknownEnv().
+		Build()
+
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	want := EnvConfig{Port: 80, Labels: map[string]string{"team": "core"}}
+	if !reflect.DeepEqual(want, cfg) {
+		t.Fatalf("want %+v, got %+v", want, cfg)
+	}
+}
+
+func TestEmptyEnv(t *testing.T) {
+	type EmptyConfig struct {
+		Port    int      `default:"8080"`
+		Name    string   `default:"app"`
+		Tags    []string `default:"a,b"`
+		Timeout int      `env:"TIMEOUT" env_aliases:"OLD_TIMEOUT"`
+	}
+
+	f := func(emptyClears bool, want EmptyConfig) {
+		t.Helper()
+
+		os.Clearenv()
+		setEnv(t, "TST_PORT", "")
+		setEnv(t, "TST_NAME", "")
+		setEnv(t, "TST_TAGS", "")
+		setEnv(t, "TST_TIMEOUT", "")
+		setEnv(t, "TST_OLD_TIMEOUT", "10")
+		defer os.Clearenv()
+
+		var cfg EmptyConfig
+		loader := LoaderFor(&cfg).
+			SkipFiles().
+			SkipFlags().
+			WithEnvPrefix("TST")
+		if emptyClears {
+			loader = loader.EmptyEnvClears()
+		}
+		loader.Build()
+
+		if err := loader.Load(&cfg); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(want, cfg) {
+			t.Fatalf("want %+v, got %+v", want, cfg)
+		}
+	}
+
+	f(false, EmptyConfig{Port: 8080, Name: "app", Tags: []string{"a", "b"}, Timeout: 10})
+	f(true, EmptyConfig{})
+}
+
+func TestBoolFlags(t *testing.T) {
+	type BoolConfig struct {
+		Verbose bool
+		Color   bool `default:"true"`
+		Debug   *bool
+		Name    string
+	}
+
+	f := func(flags []string, want BoolConfig) {
+		t.Helper()
+
+		var cfg BoolConfig
+		loader := LoaderFor(&cfg).
+			SkipFiles().
+			SkipEnvironment().
+			Build()
+
+		if err := loader.Flags().Parse(flags); err != nil {
+			t.Fatal(err)
+		}
+		if err := loader.Load(&cfg); err != nil {
+			t.Fatal(err)
+		}
+		debug := cfg.Debug != nil && *cfg.Debug
+		wantDebug := want.Debug != nil && *want.Debug
+		if cfg.Verbose != want.Verbose || cfg.Color != want.Color || cfg.Name != want.Name || debug != wantDebug {
+			t.Fatalf("want %+v, got %+v", want, cfg)
+		}
+	}
+
+	yes := true
+	f(nil, BoolConfig{Color: true})
+	f([]string{"-verbose", "-debug", "-name", "app"}, BoolConfig{Verbose: true, Color: true, Debug: &yes, Name: "app"})
+	f([]string{"-verbose=true", "-color=false"}, BoolConfig{Verbose: true})
+}
+
+func TestNegatedFlags(t *testing.T) {
+	type NegatedConfig struct {
+		Cache   bool `default:"true"`
+		Verbose bool
+		Debug   *bool
+		Name    string
+	}
+
+	f := func(flags []string, want NegatedConfig) {
+		t.Helper()
+
+		var cfg NegatedConfig
+		loader := LoaderFor(&cfg).
+			SkipFiles().
+			SkipEnvironment().
+			WithNegatedFlags().
+			Build()
+
+		if err := loader.Flags().Parse(flags); err != nil {
+			t.Fatal(err)
+		}
+		if err := loader.Load(&cfg); err != nil {
+			t.Fatal(err)
+		}
+		debug := cfg.Debug != nil && *cfg.Debug
+		wantDebug := want.Debug != nil && *want.Debug
+		if cfg.Cache != want.Cache || cfg.Verbose != want.Verbose || cfg.Name != want.Name || debug != wantDebug {
+			t.Fatalf("want %+v, got %+v", want, cfg)
+		}
+	}
+
+	yes := true
+	f(nil, NegatedConfig{Cache: true})
+	f([]string{"-no-cache", "-verbose"}, NegatedConfig{Verbose: true})
+	f([]string{"-no-cache=false", "-debug"}, NegatedConfig{Cache: true, Debug: &yes})
+
+	var cfg NegatedConfig
+	loader := LoaderFor(&cfg).
+		SkipFiles().
+		SkipEnvironment().
+		WithNegatedFlags().
+		Build()
+
+	if loader.Flags().Lookup("no-name") != nil {
+		t.Fatal("negated flag must be defined only for bool fields")
+	}
+	if err := loader.Flags().Parse([]string{"-cache", "-no-cache"}); err != nil {
+		t.Fatal(err)
+	}
+	err := loader.Load(&cfg)
+	if err == nil || !strings.Contains(err.Error(), "-cache and -no-cache") {
+		t.Fatalf("must be an error, got %v", err)
+	}
+}
+
+func TestAliases(t *testing.T) {
+	type AliasConfig struct {
+		CacheExpiry int    `env:"CACHE_EXPIRY" env_aliases:"CACHE_TTL,OLD_CACHE_TTL" deprecated:"use TST_CACHE_EXPIRY"`
+		Name        string `flag:"name" flag_aliases:"old-name, n"`
+	}
+
+	f := func(env map[string]string, flags []string, want AliasConfig, wantWarnings ...string) {
+		t.Helper()
+
+		os.Clearenv()
+		for k, v := range env {
+			setEnv(t, k, v)
+		}
+		defer os.Clearenv()
+
+		var warnings []string
+		var cfg AliasConfig
+		loader := LoaderFor(&cfg).
+			SkipFiles().
+			WithEnvPrefix("TST").
+			FailOnUnknownEnv().
+			WithLogger(func(format string, args ...interface{}) {
+				warnings = append(warnings, fmt.Sprintf(format, args...))
+			}).
+			Build()
+
+		if err := loader.Flags().Parse(flags); err != nil {
+			t.Fatal(err)
+		}
+		if err := loader.Load(&cfg); err != nil {
+			t.Fatal(err)
+		}
+		if cfg != want {
+			t.Fatalf("want %+v, got %+v", want, cfg)
+		}
+		if !reflect.DeepEqual(warnings, wantWarnings) {
+			t.Fatalf("want %v, got %v", wantWarnings, warnings)
+		}
+	}
+
+	f(map[string]string{"TST_CACHE_EXPIRY": "1", "TST_CACHE_TTL": "2"}, nil, AliasConfig{CacheExpiry: 1})
+	f(map[string]string{"TST_CACHE_TTL": "2", "TST_OLD_CACHE_TTL": "3"}, nil, AliasConfig{CacheExpiry: 2},
+		`aconfig: "TST_CACHE_TTL" of field "CacheExpiry" is deprecated: use TST_CACHE_EXPIRY`)
+	f(map[string]string{"TST_OLD_CACHE_TTL": "3"}, nil, AliasConfig{CacheExpiry: 3},
+		`aconfig: "TST_OLD_CACHE_TTL" of field "CacheExpiry" is deprecated: use TST_CACHE_EXPIRY`)
+
+	f(nil, []string{"-name=new", "-n=short"}, AliasConfig{Name: "new"})
+	f(nil, []string{"-old-name=old"}, AliasConfig{Name: "old"})
+	f(nil, []string{"-n=short"}, AliasConfig{Name: "short"})
+}
+
+func TestLoadWith(t *testing.T) {
+	setEnv(t, "TST_STR", "str-env")
+	defer os.Clearenv()
+
+	loader := LoaderFor(&TestConfig{}).
+		SkipFlags().
+		WithEnvPrefix("tst").
+		WithFiles([]string{"testdata/config1.json"}).
+		Build()
+
+	f := func(want string, opts ...LoadOption) {
+		t.Helper()
+
+		var cfg TestConfig
+		if err := loader.LoadWith(&cfg, opts...); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Str != want {
+			t.Fatalf("want %v, got %v", want, cfg.Str)
+		}
+	}
+
+	f("str-env")
+	f("str-json", WithoutEnv())
+	f("str-def", WithoutEnv(), WithoutFiles())
+	f("str-def", OnlyDefaults())
+	f("str-json", OnlyFile())
+	f("str-env", OnlyEnv())
+	f("", OnlyFlags())
+	f("", WithoutDefaults(), WithoutFiles(), WithoutEnv())
+	f("str-env", WithoutFlags())
+	f("str-json", OnlySources(SourceDefault|SourceFile))
+	f("str-env", OnlySources(SourceEnv|SourceFlag))
+
+	// base config isn't changed
+	f("str-env")
+}
+
+func TestLoadContext(t *testing.T) {
+	loader := LoaderFor(&TestConfig{}).
+		SkipFlags().
+		WithFiles([]string{"testdata/config1.json"}).
+		Build()
+
+	var cfg TestConfig
+	if err := loader.LoadContext(context.Background(), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if want := "str-json"; cfg.Str != want {
+		t.Fatalf("want %v, got %v", want, cfg.Str)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var cfg2 TestConfig
+	err := loader.LoadContext(ctx, &cfg2)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want %v, got %v", context.Canceled, err)
+	}
+	if cfg2.Str != "" {
+		t.Fatalf("want empty, got %v", cfg2.Str)
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	setEnv(t, "TST_STR", "str-env")
+	setEnv(t, "TST_INT", "121")
+	setEnv(t, "TST_HTTPPORT", "3000")
+	setEnv(t, "TST_SUB_FLOAT", "222.333")
+	setEnv(t, "TST_ANON_ISANON", "true")
+	setEnv(t, "TST_EM", "em-env")
+	defer os.Clearenv()
+
+	loader := LoaderFor(&TestConfig{}).
+		SkipDefaults().
+		SkipFiles().
+		SkipFlags().
+		WithEnvPrefix("tst").
+		Build()
+
+	var cfg TestConfig
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	var want TestConfig
+	loadFile(t, "testdata/test_config_env.json", &want)
+
+	if got := cfg; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestLoadEnv_JSON(t *testing.T) {
+	type Server struct {
+		Host string `json:"host"`
+		Port int    `json:"port"`
+	}
+	type Config struct {
+		Servers []Server          `env_format:"json"`
+		Labels  map[string]string `env_format:"json"`
+		Main    Server            `env_format:"json"`
+		Backup  *Server           `env_format:"json"`
+	}
+
+	setEnv(t, "TST_SERVERS", `[{"host":"a","port":1},{"host":"b"}]`)
+	setEnv(t, "TST_LABELS", `{"team":"payments"}`)
+	setEnv(t, "TST_MAIN", `{"host":"main","port":80}`)
+	setEnv(t, "TST_BACKUP", `{"host":"backup"}`)
+	defer os.Clearenv()
+
+	loader := LoaderFor(&Config{}).
+		SkipDefaults().
+		SkipFiles().
+		SkipFlags().
+		WithEnvPrefix("tst").
+		Build()
+
+	var cfg Config
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	want := Config{
+		Servers: []Server{{Host: "a", Port: 1}, {Host: "b"}},
+		Labels:  map[string]string{"team": "payments"},
+		Main:    Server{Host: "main", Port: 80},
+		Backup:  &Server{Host: "backup"},
+	}
+	if got := cfg; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+
+	setEnv(t, "TST_SERVERS", `[{"host":"a"`)
+	if err := loader.Load(&cfg); err == nil {
+		t.Fatal("want error for invalid JSON")
+	}
+}
+
+func TestFailOnUnknownEnv(t *testing.T) {
+	type Config struct {
+		MaxConns int
+		Host     string
+	}
+
+	setEnv(t, "TST_MAXCONNS", "10")
+	setEnv(t, "TST_HOTS", "localhost")
+	setEnv(t, "TST_UNRELATED", "1")
+	setEnv(t, "OTHER_VAR", "1")
+	defer os.Clearenv()
+
+	loader := LoaderFor(&Config{}).
+		SkipDefaults().
+		SkipFiles().
+		SkipFlags().
+		WithEnvPrefix("tst").
+		FailOnUnknownEnv().
+		Build()
+
+	var cfg Config
+	err := loader.Load(&cfg)
+	if err == nil {
+		t.Fatal("want error")
+	}
+	want := `aconfig: cannot load config: unknown environment variables: "TST_HOTS" (did you mean "TST_HOST"?), "TST_UNRELATED"`
+	if got := err.Error(); got != want {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+
+	loader = LoaderFor(&Config{}).
+		SkipDefaults().
+		SkipFiles().
+		SkipFlags().
+		WithEnvPrefix("tst").
+		FailOnUnknownEnv().
+		WithAllowedEnv([]string{"TST_HOTS", "tst_unrelated"}).
+		Build()
+
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if want := 10; cfg.MaxConns != want {
+		t.Fatalf("want %v, got %v", want, cfg.MaxConns)
+	}
+}
+
+type LazyTLSConfig struct {
+	Cert    string        `default:"cert-def"`
+	Key     string        `default:"key-def"`
+	Timeout time.Duration `default:"1s"`
+	Inner   *struct {
+		Level int `default:"1"`
+	}
+}
+
+type LazyConfig struct {
+	Name string
+	TLS  *LazyTLSConfig
+}
+
+func TestLazyPointers(t *testing.T) {
+	defer os.Clearenv()
+
+	f := func(file string, env map[string]string, flags []string) LazyConfig {
+		t.Helper()
+
+		os.Clearenv()
+		for k, v := range env {
+			setEnv(t, k, v)
+		}
+
+		loader := LoaderFor(&LazyConfig{}).
+			WithEnvPrefix("tst").
+			WithFiles([]string{file}).
+			StopOnFileError().
+			Build()
+
+		if err := loader.Flags().Parse(flags); err != nil {
+			t.Fatal(err)
+		}
+
+		var cfg LazyConfig
+		if err := loader.Load(&cfg); err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+
+	cfg := f("testdata/example_config.json", nil, nil)
+	if cfg.TLS != nil {
+		t.Fatalf("want nil, got %+v", cfg.TLS)
+	}
+
+	cfg = f("testdata/example_config.json", map[string]string{"TST_TLS_CERT": "cert-env"}, nil)
+	want := LazyTLSConfig{Cert: "cert-env", Key: "key-def", Timeout: time.Second}
+	if cfg.TLS == nil || *cfg.TLS != want {
+		t.Fatalf("want %+v, got %+v", want, cfg.TLS)
+	}
+
+	cfg = f("testdata/example_config.json", nil, []string{"-tls.inner.level=2"})
+	if cfg.TLS == nil || cfg.TLS.Inner == nil || cfg.TLS.Inner.Level != 2 {
+		t.Fatalf("want inner level 2, got %+v", cfg.TLS)
+	}
+	if want := "key-def"; cfg.TLS.Key != want {
+		t.Fatalf("want %v, got %v", want, cfg.TLS.Key)
+	}
+
+	for _, file := range []string{"testdata/lazy_config.json", "testdata/lazy_config.toml", "testdata/lazy_config.yaml"} {
+		cfg = f(file, map[string]string{"TST_TLS_KEY": "key-env"}, nil)
+		if cfg.TLS == nil {
+			t.Fatalf("%s: want not nil", file)
+		}
+		if !strings.HasPrefix(cfg.TLS.Cert, "cert-") || cfg.TLS.Cert == "cert-def" {
+			t.Fatalf("%s: want cert from file, got %v", file, cfg.TLS.Cert)
+		}
+		if want := "key-env"; cfg.TLS.Key != want {
+			t.Fatalf("%s: want %v, got %v", file, want, cfg.TLS.Key)
+		}
+		if want := 5 * time.Second; cfg.TLS.Timeout != want {
+			t.Fatalf("%s: want %v, got %v", file, want, cfg.TLS.Timeout)
+		}
+		if cfg.TLS.Inner != nil {
+			t.Fatalf("%s: want nil inner, got %+v", file, cfg.TLS.Inner)
+		}
+	}
+}
+
+func TestLazyPointers_NotNil(t *testing.T) {
+	loader := LoaderFor(&LazyConfig{}).
+		SkipFiles().
+		SkipEnvironment().
+		SkipFlags().
+		Build()
+
+	cfg := LazyConfig{TLS: &LazyTLSConfig{Cert: "cert-set"}}
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	want := LazyTLSConfig{Cert: "cert-def", Key: "key-def", Timeout: time.Second}
+	if cfg.TLS == nil || *cfg.TLS != want {
+		t.Fatalf("want %+v, got %+v", want, cfg.TLS)
+	}
+}
+
+type RecursiveNode struct {
+	Name  string `default:"root"`
+	Next  *RecursiveNode
+	Child *RecursiveChild
+}
+
+type RecursiveChild struct {
+	Port   int `default:"80"`
+	Parent *RecursiveNode
+}
+
+func TestLazyPointers_Recursive(t *testing.T) {
+	os.Clearenv()
+	setEnv(t, "TST_CHILD_PORT", "8080")
+	defer os.Clearenv()
+
+	var cfg RecursiveNode
+	loader := LoaderFor(&cfg).
+		SkipFiles().
+		SkipFlags().
+		WithEnvPrefix("TST").
+		Build()
+
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Name != "root" {
+		t.Fatalf("want %v, got %v", "root", cfg.Name)
+	}
+	if cfg.Child == nil || cfg.Child.Port != 8080 {
+		t.Fatalf("want %v, got %+v", 8080, cfg.Child)
+	}
+	// recursive pointers are single fields, their fields aren't walked
+	if cfg.Next != nil && cfg.Next.Next != nil {
+		t.Fatalf("want no nested node, got %+v", cfg.Next)
+	}
+}
+
+func TestLazyPointers_LoadError(t *testing.T) {
+	type BadLazyConfig struct {
+		LazyConfig
+		Port int
+	}
+
+	f := func(env map[string]string) {
+		t.Helper()
+
+		var cfg BadLazyConfig
+		loader := LoaderFor(&cfg).
+			SkipFiles().
+			SkipFlags().
+			WithEnvPrefix("TST").
+			WithEnv(env).
+			Build()
+
+		if err := loader.Load(&cfg); err == nil {
+			t.Fatal("must be an error")
+		}
+		if cfg.TLS != nil {
+			t.Fatalf("want nil, got %+v", cfg.TLS)
+		}
+	}
+
+	f(map[string]string{"TST_PORT": "abc"})
+	f(map[string]string{"TST_TLS_INNER_LEVEL": "abc"})
+}
+
+func TestLoadFlag(t *testing.T) {
+	loader := LoaderFor(&TestConfig{}).
+		SkipDefaults().
+		SkipFiles().
+		SkipEnvironment().
+		WithFlagPrefix("tst").
+		Build()
+
+	flags := []string{
+		"-tst.str=str-flag",
+		"-tst.int=1001",
+		"-tst.int=1001",
+		"-tst.httpport=30000",
+		"-tst.sub.float=123.321",
+		"-tst.anon.isanon=true",
+		"-tst.em=em-flag",
+	}
+
+	// hack for test :(
+	if err := loader.Flags().Parse(flags); err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg TestConfig
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	var want TestConfig
+	loadFile(t, "testdata/test_config_flag.json", &want)
+
+	if got := cfg; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	loader := LoaderFor(&EmbeddedConfig{}).Build()
+
+	var builder strings.Builder
+	flags := loader.Flags()
+	flags.SetOutput(&builder)
+	flags.PrintDefaults()
+
+	got := builder.String()
+	want := `  -em string
+    	use... em...field. (default "em-def")
+`
+
+	if got != want {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	type NumbersConfig struct {
+		NegDuration  time.Duration `default:"-500ms"`
+		FracDuration time.Duration `default:"1.5h"`
+		Float        float64       `default:"1e3"`
+		NegFloat     float32       `default:"-2.5E-2"`
+		Inf          float64       `default:"-inf" allow_inf:"true"`
+		NaNs         []float64     `default:"NaN,1" allow_inf:"true"`
+		Hex          int           `default:"-0x10"`
+	}
+
+	var cfg NumbersConfig
+	loader := LoaderFor(&cfg).
+		SkipFiles().
+		SkipEnvironment().
+		SkipFlags().
+		Build()
+
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case cfg.NegDuration != -500*time.Millisecond:
+		t.Fatalf("got %v", cfg.NegDuration)
+	case cfg.FracDuration != 90*time.Minute:
+		t.Fatalf("got %v", cfg.FracDuration)
+	case cfg.Float != 1000:
+		t.Fatalf("got %v", cfg.Float)
+	case cfg.NegFloat != -0.025:
+		t.Fatalf("got %v", cfg.NegFloat)
+	case !math.IsInf(cfg.Inf, -1):
+		t.Fatalf("got %v", cfg.Inf)
+	case len(cfg.NaNs) != 2 || !math.IsNaN(cfg.NaNs[0]) || cfg.NaNs[1] != 1:
+		t.Fatalf("got %v", cfg.NaNs)
+	case cfg.Hex != -16:
+		t.Fatalf("got %v", cfg.Hex)
+	}
+}
+
+func TestBadNumbers(t *testing.T) {
+	f := func(cfg interface{}, want string) {
+		t.Helper()
+
+		loader := LoaderFor(cfg).
+			SkipFiles().
+			SkipEnvironment().
+			SkipFlags().
+			Build()
+
+		err := loader.Load(cfg)
+		if err == nil {
+			t.Fatal("must be an error")
+		}
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("want %q in %q", want, err.Error())
+		}
+	}
+
+	f(&struct {
+		Int int `default:"1a"`
+	}{}, `cannot parse "1a" as int: invalid syntax`)
+
+	f(&struct {
+		Int8 int8 `default:"300"`
+	}{}, `cannot parse "300" as int8: value out of range`)
+
+	f(&struct {
+		Uint uint `default:"-1"`
+	}{}, `cannot parse "-1" as uint: invalid syntax`)
+
+	f(&struct {
+		Float float64 `default:"1e"`
+	}{}, `cannot parse "1e" as float64: invalid syntax`)
+
+	f(&struct {
+		Float float64 `default:"inf"`
+	}{}, `cannot parse "inf" as float64: Inf and NaN aren't allowed`)
+
+	f(&struct {
+		Floats []float32 `default:"1,NaN"`
+	}{}, `cannot parse "NaN" as float32: Inf and NaN aren't allowed`)
+
+	f(&struct {
+		Duration time.Duration `default:"5 sec"`
+	}{}, `cannot parse "5 sec" as duration`)
+}
+
+func TestBadDefauts(t *testing.T) {
+	f := func(cfg interface{}) {
+		t.Helper()
+
+		loader := LoaderFor(cfg).
+			SkipFiles().
+			SkipEnvironment().
+			SkipFlags().
+			Build()
+
+		if err := loader.Load(cfg); err == nil {
+			t.Fatal(err)
+		}
+	}
+
+	f(&struct {
+		Bool bool `default:"omg"`
+	}{})
+
+	f(&struct {
+		Bool bool `default:"omg"`
+	}{})
+
+	f(&struct {
+		Int int `default:"1a"`
+	}{})
+
+	f(&struct {
+		Int8 int8 `default:"12a"`
+	}{})
+
+	f(&struct {
+		Int16 int16 `default:"123a"`
+	}{})
+
+	f(&struct {
+		Int32 int32 `default:"13a"`
+	}{})
+
+	f(&struct {
+		Int64 int64 `default:"23a"`
+	}{})
+
+	f(&struct {
+		Uint uint `default:"1234a"`
+	}{})
+
+	f(&struct {
+		Uint8 uint8 `default:"124a"`
+	}{})
+
+	f(&struct {
+		Uint16 uint16 `default:"134a"`
+	}{})
+
+	f(&struct {
+		Uint32 uint32 `default:"234a"`
+	}{})
+
+	f(&struct {
+		Uint64 uint64 `default:"24a"`
+	}{})
+
+	f(&struct {
+		Float32 float32 `default:"1234x213"`
+	}{})
+
+	f(&struct {
+		Float64 float64 `default:"1234x234"`
+	}{})
+
+	f(&struct {
+		Dur time.Duration `default:"1h_2m3s"`
+	}{})
+
+	f(&struct {
+		Slice []int `default:"1,a,2"`
+	}{})
+
+	f(&struct {
+		Map map[string]int `default:"1:a,2:2"`
+	}{})
+
+	f(&struct {
+		Map map[int]string `default:"a:1"`
+	}{})
+
+	f(&struct {
+		Map map[int]string `default:"a1"`
+	}{})
+
+	f(&struct {
+		Array [2]string `default:"a1"`
+	}{})
+
+	f(&struct {
+		Slice []int `default:"[1, 2"`
+	}{})
+
+	f(&struct {
+		Map map[string]int `default:"{\"a\": \"b\"}"`
+	}{})
+}
+
+func TestSkipUnsupportedFields(t *testing.T) {
+	type Config struct {
+		sync.Mutex
+
+		Str  string        `default:"str-def"`
+		Func func() error  `default:"func-def"`
+		Chan chan struct{} `default:"chan-def"`
+		Ptr  *func()       `default:"ptr-def"`
+		Mu   sync.RWMutex
+	}
+
+	loader := LoaderFor(&Config{}).
+		SkipFiles().
+		SkipEnvironment().
+		Build()
+
+	if err := loader.Flags().Parse([]string{"-str=str-flag"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg Config
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "str-flag"; cfg.Str != want {
+		t.Fatalf("want %v, got %v", want, cfg.Str)
+	}
+	if cfg.Func != nil || cfg.Chan != nil || cfg.Ptr != nil {
+		t.Fatal("want nil func and chan fields")
+	}
+
+	count := 0
+	loader.WalkFields(func(f Field) bool {
+		count++
+		return true
+	})
+	if want := 1; count != want {
+		t.Fatalf("want %v, got %v", want, count)
+	}
+}
+
+func TestNotParsedFlags(t *testing.T) {
+	loader := LoaderFor(&TestConfig{}).
+		FailOnNotParsedFlags().
+		Build()
+
+	var cfg TestConfig
+	if err := loader.Load(&cfg); err == nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBadFiles(t *testing.T) {
+	f := func(filepath string) {
+		t.Helper()
+
+		loader := LoaderFor(&TestConfig{}).
+			SkipDefaults().
+			SkipEnvironment().
+			SkipFlags().
+			StopOnFileError().
+			WithFiles([]string{filepath}).
+			Build()
+
+		var cfg TestConfig
+		if err := loader.Load(&cfg); err == nil {
+			t.Fatal(err)
+		}
+	}
+
+	f("testdata/no_such_file.json")
+	f("testdata/bad_config.json")
+	f("testdata/unknown.ext")
+	f("testdata/corrupted.json.gz")
+}
+
+func TestUnsupportedFormat(t *testing.T) {
+	f := func(file, want string) {
+		t.Helper()
+
+		loader := LoaderFor(&TestConfig{}).
+			SkipDefaults().
+			SkipEnvironment().
+			SkipFlags().
+			WithFiles([]string{file}).
+			Build()
+
+		var cfg TestConfig
+		err := loader.Load(&cfg)
+		if err == nil {
+			t.Fatal("want error")
+		}
+		if got := err.Error(); got != want {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	}
+
+	f("testdata/unknown.ext", `aconfig: cannot load config: file "testdata/unknown.ext" has unsupported format ".ext", supported formats: .json, .yaml, .yml, .toml`)
+	f("testdata/example_config", `aconfig: cannot load config: file "testdata/example_config" has no extension, set default format to one of: .json, .yaml, .yml, .toml`)
+}
+
+func TestDefaultFormat(t *testing.T) {
+	type Config struct {
+		Port int
+	}
+
+	loader := LoaderFor(&Config{}).
+		SkipDefaults().
+		SkipEnvironment().
+		SkipFlags().
+		WithDefaultFormat("JSON").
+		WithFiles([]string{"testdata/example_config"}).
+		Build()
+
+	var cfg Config
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if want := 2222; cfg.Port != want {
+		t.Fatalf("want %v, got %v", want, cfg.Port)
+	}
+}
+
+func TestContinueOnFileError(t *testing.T) {
+	loader := LoaderFor(&TestConfig{}).
+		SkipDefaults().
+		SkipEnvironment().
+		SkipFlags().
+		ContinueOnFileError().
+		WithFiles([]string{
+			"testdata/no_such_file.json",
+			"testdata/bad_config.json",
+			"testdata/unknown.ext",
+			"testdata/corrupted.json.gz",
+			"testdata/config1.json",
+		}).
+		Build()
+
+	var cfg TestConfig
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if want := "str-json"; cfg.Str != want {
+		t.Fatalf("want %v, got %v", want, cfg.Str)
+	}
+
+	errs := loader.FileErrors()
+	if len(errs) != 3 {
+		t.Fatalf("want 3 errors, got %v", errs)
+	}
+	for i, file := range []string{"bad_config.json", "unknown.ext", "corrupted.json.gz"} {
+		if !strings.Contains(errs[i].Error(), file) {
+			t.Fatalf("want error for %v, got %v", file, errs[i])
+		}
+	}
+
+	var cfg2 TestConfig
+	if err := loader.LoadWithFile(&cfg2, "testdata/config1.json"); err != nil {
+		t.Fatal(err)
+	}
+	if errs := loader.FileErrors(); len(errs) != 0 {
+		t.Fatalf("want no errors, got %v", errs)
+	}
+}
+
+func TestBadEnvs(t *testing.T) {
+	setEnv(t, "TST_HTTPPORT", "30a00")
+	defer os.Clearenv()
+
+	loader := LoaderFor(&TestConfig{}).
+		SkipDefaults().
+		SkipFiles().
+		SkipFlags().
+		WithEnvPrefix("tst").
+		Build()
+
+	var cfg TestConfig
+	if err := loader.Load(&cfg); err == nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBadFlags(t *testing.T) {
+	type Config struct {
+		Field int
+	}
+
+	loader := LoaderFor(&Config{}).
+		SkipDefaults().
+		SkipFiles().
+		SkipEnvironment().
+		WithFlagPrefix("tst").
+		Build()
+
+	// hack for test :(
+	if err := loader.Flags().Parse([]string{"-tst.field=10a01"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg Config
+	if err := loader.Load(&cfg); err == nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCustomNames(t *testing.T) {
+	type Config struct {
+		A int `default:"-1" env:"one"`
+		B int `default:"-1" flag:"two"`
+		C int `default:"-1" env:"three" flag:"four"`
+	}
+
+	loader := LoaderFor(&Config{}).Build()
+
+	setEnv(t, "ONE", "1")
+	setEnv(t, "three", "3")
+	defer os.Clearenv()
+
+	if err := loader.Flags().Parse([]string{"-two=2", "-four=4"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg Config
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := 1; cfg.A != want {
+		t.Errorf("got %#v, want %#v", cfg.A, want)
+	}
+	if want := 2; cfg.B != want {
+		t.Errorf("got %#v, want %#v", cfg.B, want)
+	}
+	if want := 4; cfg.C != want {
+		t.Errorf("got %#v, want %#v", cfg.C, want)
+	}
+}
+
+func TestUseFileTags(t *testing.T) {
+	type Config struct {
+		Database struct {
+			MaxConnections int    `yaml:"max_connections"`
+			Host           string `yaml:"host"`
+			Skipped        int    `yaml:"-"`
+			NoTag          int
+		} `yaml:"database"`
+	}
+
+	loader := LoaderFor(&Config{}).
+		SkipDefaults().
+		UseFileTags().
+		WithEnvPrefix("TST").
+		WithFiles([]string{"testdata/no_such_file.yaml"}).
+		Build()
+
+	setEnv(t, "TST_DATABASE_MAX_CONNECTIONS", "42")
+	setEnv(t, "TST_DATABASE_SKIPPED", "1")
+	setEnv(t, "TST_DATABASE_NOTAG", "2")
+	defer os.Clearenv()
+
+	if err := loader.Flags().Parse([]string{"-database.host=db.local"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg Config
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := 42; cfg.Database.MaxConnections != want {
+		t.Fatalf("want %v, got %v", want, cfg.Database.MaxConnections)
+	}
+	if want := "db.local"; cfg.Database.Host != want {
+		t.Fatalf("want %v, got %v", want, cfg.Database.Host)
+	}
+	if want := 1; cfg.Database.Skipped != want {
+		t.Fatalf("want %v, got %v", want, cfg.Database.Skipped)
+	}
+	if want := 2; cfg.Database.NoTag != want {
+		t.Fatalf("want %v, got %v", want, cfg.Database.NoTag)
+	}
+}
+
+func TestSplitWordsNames(t *testing.T) {
+	type Config struct {
+		HTTP struct {
+			HTTPPort int
+			MaxConns int `env:"CONNS" flag:"conns"`
+		}
+	}
+
+	setEnv(t, "TST_HTTP_HTTP_PORT", "8080")
+	setEnv(t, "TST_CONNS", "10")
+	defer os.Clearenv()
+
+	loader := LoaderFor(&Config{}).
+		SkipDefaults().
+		SkipFiles().
+		SkipFlags().
+		WithEnvPrefix("TST").
+		SplitEnvWords().
+		Build()
+
+	var cfg Config
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.HTTP.HTTPPort != 8080 || cfg.HTTP.MaxConns != 10 {
+		t.Fatalf("want 8080 and 10, got %+v", cfg)
+	}
+
+	loader = LoaderFor(&Config{}).
+		SkipDefaults().
+		SkipFiles().
+		SkipEnvironment().
+		SplitFlagWords("-").
+		Build()
+
+	if err := loader.Flags().Parse([]string{"-http.http-port=9090", "-conns=20"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.HTTP.HTTPPort != 9090 || cfg.HTTP.MaxConns != 20 {
+		t.Fatalf("want 9090 and 20, got %+v", cfg)
+	}
+}
+
+func TestWalkFields(t *testing.T) {
+	type Config struct {
+		A int `default:"-1" env:"one" marco:"polo"`
+		B struct {
+			C int `default:"-1" flag:"two" usage:"pretty simple usage duh"`
+			D struct {
+				E int `default:"-1" env:"three"`
+			}
+		}
+	}
+
+	fields := []struct {
+		Name         string
+		ParentName   string
+		DefaultValue string
+		EnvName      string
+		FlagName     string
+		Usage        string
+	}{
+		{
+			Name:         "A",
+			EnvName:      "one",
+			DefaultValue: "-1",
+		},
+		{
+			Name:         "B.C",
+			ParentName:   "B",
+			FlagName:     "two",
+			DefaultValue: "-1",
+			Usage:        "pretty simple usage duh",
+		},
+		{
+			Name:         "B.D.E",
+			ParentName:   "B.D",
+			EnvName:      "three",
+			DefaultValue: "-1",
+		},
+	}
+
+	i := 0
+
+	LoaderFor(&Config{}).Build().WalkFields(func(f Field) bool {
+		wantFields := fields[i]
+		if f.Name() != wantFields.Name {
+			t.Fatalf("got name %v, want %v", f.Name(), wantFields.Name)
+		}
+
+		if parent, ok := f.Parent(); ok && parent.Name() != wantFields.ParentName {
+			t.Fatalf("got name %v, want %v", parent.Name(), wantFields.ParentName)
+		}
+		if f.DefaultValue() != wantFields.DefaultValue {
+			t.Fatalf("got default %#v, want %#v", f.DefaultValue(), wantFields.DefaultValue)
+		}
+		if f.Usage() != wantFields.Usage {
+			t.Fatalf("got usage %#v, want %#v", f.Usage(), wantFields.Usage)
+		}
+		i++
+		return true
+	})
+
+	if want := 3; i != want {
+		t.Fatalf("got %v, want %v", i, want)
+	}
+
+	i = 0
+	LoaderFor(&Config{}).Build().WalkFields(func(f Field) bool {
+		if i > 0 {
+			return false
+		}
+		if got := f.Tag("marco"); got != "polo" {
+			t.Fatalf("got %v, want %v", got, "polo")
+		}
+		i++
+		return true
+	})
+	if i != 1 {
+		t.Fatal()
+	}
+}
+
+func TestFieldsOrder(t *testing.T) {
+	type Inner struct {
+		X int
+		Y int
+	}
+	type Config struct {
+		A int
+		EmbeddedConfig
+		B struct {
+			C int
+			Inner
+			D Inner
+			E int
+		}
+		F map[string]int
+		Inner
+		G []int
+	}
+
+	want := []string{
+		"A",
+		"Em",
+		"B.C",
+		"B.X",
+		"B.Y",
+		"B.D.X",
+		"B.D.Y",
+		"B.E",
+		"F",
+		"X",
+		"Y",
+		"G",
+	}
+
+	for i := 0; i < 3; i++ {
+		fields := LoaderFor(&Config{}).Build().Fields()
+
+		got := make([]string, 0, len(fields))
+		for _, f := range fields {
+			got = append(got, f.Name())
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	}
+}
+
+func TestDontFillFlagsIfDisabled(t *testing.T) {
+	type Config struct {
+		A int `default:"1"`
+	}
+
+	loader := LoaderFor(&Config{}).
+		SkipFlags().
+		Build()
+
+	if flags := loader.Flags().NFlag(); flags != 0 {
+		t.Errorf("want empty, got %v", flags)
+	}
+}
+
+func TestPassNonStructs(t *testing.T) {
+	f := func(cfg interface{}) {
+		t.Helper()
+
+		if err := LoaderFor(nil).Build().Load(cfg); err == nil {
+			t.Fatal("must be an error")
+		}
+	}
+
+	f(nil)
+	f(map[string]string{})
+	f([]string{})
+	f([4]string{})
+	f(func() {})
+	f((*TestConfig)(nil))
+	f(&[]string{})
+	f(&map[string]string{})
+
+	fn := func() {}
+	f(&fn)
+}
+
+func TestLoadNonStructs(t *testing.T) {
+	f := func(cfg interface{}, data, format string, want interface{}) {
+		t.Helper()
+
+		loader := LoaderFor(cfg).
+			SkipEnvironment().
+			SkipFlags().
+			Build()
+
+		if err := loader.LoadBytes(cfg, []byte(data), format); err != nil {
+			t.Fatal(err)
+		}
+		if got := reflect.ValueOf(cfg).Elem().Interface(); !reflect.DeepEqual(want, got) {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	}
+
+	type ServerConfig struct {
+		Host string `json:"host" yaml:"host"`
+		Port int    `json:"port" yaml:"port"`
+	}
+
+	f(&[]ServerConfig{}, `[{"host": "a", "port": 1}, {"host": "b", "port": 2}]`, "json",
+		[]ServerConfig{{Host: "a", Port: 1}, {Host: "b", Port: 2}})
+	f(&[]ServerConfig{}, "- host: a\n  port: 1", "yaml", []ServerConfig{{Host: "a", Port: 1}})
+	f(&map[string]string{}, "a: b\nc: d", "yaml", map[string]string{"a": "b", "c": "d"})
+	f(&map[string]int{}, "a = 1", "toml", map[string]int{"a": 1})
+}
+
+type CommonConfig struct {
+	Host string `default:"localhost"`
+	Port int
+}
+
+type ExtraConfig struct {
+	Level string
+}
+
+type BaseConfig struct {
+	*CommonConfig
+	Name string `default:"base"`
+}
+
+type ComposedConfig struct {
+	BaseConfig
+	*ExtraConfig
+	Nested struct {
+		CommonConfig
+	}
+	NestedPtr struct {
+		*CommonConfig
+	}
+}
+
+func TestEmbeddedPointers(t *testing.T) {
+	f := func(env map[string]string, data string, want ComposedConfig) {
+		t.Helper()
+
+		var cfg ComposedConfig
+		loader := LoaderFor(&cfg).
+			SkipFlags().
+			WithEnvPrefix("TST").
+			WithEnv(env).
+			Build()
+
+		if err := loader.LoadBytes(&cfg, []byte(data), "json"); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(want, cfg) {
+			t.Fatalf("want %+v, got %+v", want, cfg)
+		}
+	}
+
+	// embedded pointers are allocated even if only defaults are set
+	var want ComposedConfig
+	want.CommonConfig = &CommonConfig{Host: "localhost"}
+	want.Name = "base"
+	want.ExtraConfig = &ExtraConfig{}
+	want.Nested.Host = "localhost"
+	want.NestedPtr.CommonConfig = &CommonConfig{Host: "localhost"}
+	f(nil, `{}`, want)
+
+	var cfg ComposedConfig
+	if err := LoaderFor(&cfg).SkipFiles().SkipEnvironment().SkipFlags().Build().Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Host != "localhost" || cfg.NestedPtr.Host != "localhost" {
+		t.Fatalf("want promoted defaults, got %+v", cfg)
+	}
+
+	want = ComposedConfig{}
+	want.CommonConfig = &CommonConfig{Host: "localhost", Port: 80}
+	want.Name = "base"
+	want.ExtraConfig = &ExtraConfig{Level: "debug"}
+	want.Nested.Host = "nested"
+	want.NestedPtr.CommonConfig = &CommonConfig{Host: "localhost", Port: 90}
+	f(
+		map[string]string{"TST_PORT": "80", "TST_NESTED_HOST": "nested", "TST_NESTEDPTR_PORT": "90"},
+		`{"Level": "debug"}`,
+		want,
+	)
+
+	var names []string
+	loader := LoaderFor(&ComposedConfig{}).SkipFlags().Build()
+	loader.WalkFields(func(f Field) bool {
+		names = append(names, f.Name())
+		return true
+	})
+	wantNames := []string{"Host", "Port", "Name", "Level", "Nested.Host", "Nested.Port", "NestedPtr.Host", "NestedPtr.Port"}
+	if !reflect.DeepEqual(wantNames, names) {
+		t.Fatalf("want %v, got %v", wantNames, names)
+	}
+}
+
+func TestLoaderForNilPointer(t *testing.T) {
+	loader := LoaderFor((*TestConfig)(nil)).
+		SkipFiles().
+		SkipEnvironment().
+		Build()
+
+	if loader.Flags().Lookup("str") == nil {
+		t.Fatal("flags must be registered for a nil pointer")
+	}
+}
+
+func TestParseInto(t *testing.T) {
+	var dur time.Duration
+	if err := ParseInto(&dur, "1h30m"); err != nil {
+		t.Fatal(err)
+	}
+	if want := 90 * time.Minute; dur != want {
+		t.Fatalf("want %v, got %v", want, dur)
+	}
+
+	var num uint16
+	if err := ParseInto(&num, "0x10"); err != nil {
+		t.Fatal(err)
+	}
+	if want := uint16(16); num != want {
+		t.Fatalf("want %v, got %v", want, num)
+	}
+
+	var durs map[string][]time.Duration
+	if err := ParseInto(&durs, "a:1s"); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string][]time.Duration{"a": {time.Second}}; !reflect.DeepEqual(durs, want) {
+		t.Fatalf("want %v, got %v", want, durs)
+	}
+
+	var ptr *int
+	if err := ParseInto(&ptr, "42"); err != nil {
+		t.Fatal(err)
+	}
+	if ptr == nil || *ptr != 42 {
+		t.Fatalf("want %v, got %v", 42, ptr)
+	}
+
+	if err := ParseInto(&num, "-1"); err == nil {
+		t.Fatal("want error")
+	}
+	if err := ParseInto(num, "1"); err == nil {
+		t.Fatal("want error for non-pointer")
+	}
+	if err := ParseInto((*int)(nil), "1"); err == nil {
+		t.Fatal("want error for nil pointer")
+	}
+}
+
+func TestPanicWhenNotBuilt(t *testing.T) {
+	f := func(fn func()) {
+		t.Helper()
+
+		defer func() {
+			t.Helper()
+			if err := recover(); err == nil {
+				t.Fatal()
+			}
+		}()
+		fn()
+	}
+
+	// ok to pass nils
+	f(func() {
+		_ = LoaderFor(nil).Load(nil)
+	})
+	f(func() {
+		_ = LoaderFor(nil).LoadWithFile(nil, "")
+	})
+	f(func() {
+		_ = LoaderFor(nil).Flags()
+	})
+	f(func() {
+		LoaderFor(nil).WalkFields(nil)
+	})
+}
+
+func loadFile(t *testing.T, file string, dst interface{}) {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ext := strings.ToLower(filepath.Ext(file))
+	switch ext {
+	case ".yaml", ".yml":
+		err = yaml.NewDecoder(f).Decode(dst)
+	case ".json":
+		err = json.NewDecoder(f).Decode(dst)
+	case ".toml":
+		_, err = toml.DecodeReader(f, dst)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setEnv(t *testing.T, key, value string) {
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type ValidatedConfig struct {
+	Host  string `required:"true"`
+	Port  int    `default:"8080" min:"1" max:"65535"`
+	Level string `default:"info" oneof:"debug,info,warn"`
+	Tags  []string
+}
+
+func (c *ValidatedConfig) Validate() error {
+	if len(c.Tags) > 2 {
+		return errors.New("too many tags")
+	}
+	return nil
+}
+
+func TestValidate(t *testing.T) {
+	loader := LoaderFor(&ValidatedConfig{}).
+		SkipFiles().
+		SkipEnvironment().
+		SkipFlags().
+		SkipValidation().
+		Build()
+
+	var cfg ValidatedConfig
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := loader.Validate(); err == nil {
+		t.Fatal("want error for missing required field")
+	}
+
+	cfg.Host = "localhost"
+	if err := loader.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Tags = []string{"a", "b", "c"}
+	if err := loader.Validate(); err == nil {
+		t.Fatal("want error from Validate method")
+	}
+}
+
+func TestValidateOnLoad(t *testing.T) {
+	loader := LoaderFor(&ValidatedConfig{}).
+		SkipFiles().
+		SkipEnvironment().
+		SkipFlags().
+		Build()
+
+	var cfg ValidatedConfig
+	if err := loader.Load(&cfg); err == nil {
+		t.Fatal("want error for missing required field")
+	}
+}
+
+func TestBadValidation(t *testing.T) {
+	f := func(cfg interface{}) {
+		t.Helper()
+
+		loader := LoaderFor(cfg).
+			SkipFiles().
+			SkipEnvironment().
+			SkipFlags().
+			Build()
+
+		if err := loader.Load(cfg); err == nil {
+			t.Fatal(err)
+		}
+	}
+
+	f(&struct {
+		Int *int `required:"true"`
+	}{})
+
+	f(&struct {
+		Int int `default:"-1" min:"0"`
+	}{})
+
+	f(&struct {
+		Uint uint `default:"11" max:"10"`
+	}{})
+
+	f(&struct {
+		Float float64 `default:"0.5" min:"0.6"`
+	}{})
+
+	f(&struct {
+		Str string `default:"abc" max:"2"`
+	}{})
+
+	f(&struct {
+		Slice []int `default:"1,2,3" min:"4"`
+	}{})
+
+	f(&struct {
+		Int int `default:"1" min:"a"`
+	}{})
+
+	f(&struct {
+		Bool bool `default:"true" min:"1"`
+	}{})
+
+	f(&struct {
+		Str string `default:"trace" oneof:"debug,info"`
+	}{})
+
+	f(&struct {
+		Str string `default:"Bad Name" regex:"^[a-z-]+$"`
+	}{})
+
+	f(&struct {
+		Str string `default:"abc" regex:"[a-"`
+	}{})
+
+	f(&struct {
+		Int int `default:"1" regex:"^1$"`
+	}{})
+
+	f(&struct {
+		Str string `required:"true" oneof:"debug,info"`
+	}{})
+}
+
+func TestValidateZeroValues(t *testing.T) {
+	type OptionalConfig struct {
+		Port  int      `min:"1" max:"65535"`
+		Tags  []string `min:"1"`
+		Level string   `oneof:"debug,info"`
+		Name  string   `regex:"^[a-z-]+$"`
+		Mode  string   `default:"fast" oneof:"fast,slow"`
+		Slug  string   `default:"my-app" regex:"^[a-z-]+$"`
+	}
+
+	var cfg OptionalConfig
+	loader := LoaderFor(&cfg).
+		SkipFiles().
+		SkipEnvironment().
+		SkipFlags().
+		Build()
+
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSkipValidation(t *testing.T) {
+	var cfg ValidatedConfig
+	loader := LoaderFor(&cfg).
+		SkipFiles().
+		SkipEnvironment().
+		SkipFlags().
+		SkipValidation().
+		Build()
+
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type GenerateConfig struct {
+	Str   string `default:"str-def"`
+	Int   int    `default:"8080"`
+	Empty string
+	Zero  int `default:"0"`
+	Sub   struct {
+		Dur   time.Duration `default:"1h2m3s"`
+		Slice []int         `default:"1,2,3"`
+		Flag  bool
+	}
+	EmbeddedConfig
+}
+
+func TestGenerateYAML(t *testing.T) {
+	data, err := LoaderFor(&GenerateConfig{}).Build().GenerateYAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `str: str-def
+int: 8080
+sub:
+  dur: 1h2m3s
+  slice:
+  - 1
+  - 2
+  - 3
+embeddedconfig:
+  em: em-def
+`
+	if got := string(data); got != want {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestGenerateYAML_IncludeZeroDefaults(t *testing.T) {
+	data, err := LoaderFor(&GenerateConfig{}).IncludeZeroDefaults().Build().GenerateYAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `str: str-def
+int: 8080
+empty: ""
+zero: 0
+sub:
+  dur: 1h2m3s
+  slice:
+  - 1
+  - 2
+  - 3
+  flag: false
+embeddedconfig:
+  em: em-def
+`
+	if got := string(data); got != want {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+type GenerateTagsConfig struct {
+	MaxConns int               `yaml:"max_conns" default:"10"`
+	Name     string            `yaml:"name,omitempty"`
+	Secret   string            `yaml:"-" default:"secret"`
+	Addr     string            `yaml:"addr" default:"${Host}:80"`
+	Host     string            `yaml:"host" default:"localhost"`
+	Labels   map[string]string `yaml:"labels" default:"team:core"`
+	DB       struct {
+		Timeout time.Duration `yaml:"timeout" default:"5s"`
+		Params  []string      `yaml:"params" default:"a,b"`
+	} `yaml:"db"`
+	Inlined  `yaml:",inline"`
+	Embedded `yaml:"embedded"`
+}
+
+type Inlined struct {
+	Level string `yaml:"level" default:"info"`
+}
+
+type Embedded struct {
+	Retries int `yaml:"retries" default:"3"`
+}
+
+func TestGenerateYAML_Tags(t *testing.T) {
+	data, err := LoaderFor(&GenerateTagsConfig{}).IncludeZeroDefaults().Build().GenerateYAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `max_conns: 10
+host: localhost
+labels:
+  team: core
+db:
+  timeout: 5s
+  params:
+  - a
+  - b
+level: info
+embedded:
+  retries: 3
+`
+	if got := string(data); got != want {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestGenerateYAML_RoundTrip(t *testing.T) {
+	f := func(cfg, fromDefaults, fromFile interface{}) {
+		t.Helper()
+
+		data, err := LoaderFor(cfg).SkipEnvironment().SkipFlags().Build().GenerateYAML()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = LoaderFor(fromDefaults).SkipFiles().SkipEnvironment().SkipFlags().Build().Load(fromDefaults)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		loader := LoaderFor(fromFile).SkipDefaults().SkipEnvironment().SkipFlags().Build()
+		if err := loader.LoadBytes(fromFile, data, "yaml"); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(fromDefaults, fromFile) {
+			t.Fatalf("want %+v, got %+v\n%s", fromDefaults, fromFile, data)
+		}
+	}
+
+	f(&GenerateConfig{}, &GenerateConfig{}, &GenerateConfig{})
+
+	type Config struct {
+		MaxConns int               `yaml:"max_conns" default:"10"`
+		Name     string            `yaml:"name,omitempty"`
+		Host     string            `yaml:"host" default:"localhost"`
+		Labels   map[string]string `yaml:"labels" default:"team:core"`
+		DB       struct {
+			Timeout time.Duration `yaml:"timeout" default:"5s"`
+			Params  []string      `yaml:"params" default:"a,b"`
+		} `yaml:"db"`
+		Inlined  `yaml:",inline"`
+		Embedded `yaml:"embedded"`
+	}
+	f(&Config{}, &Config{}, &Config{})
+}
+
+func TestGenerateYAML_BadDefault(t *testing.T) {
+	type Config struct {
+		Int int `default:"abc"`
+	}
+
+	if _, err := LoaderFor(&Config{}).Build().GenerateYAML(); err == nil {
+		t.Fatal("want error")
+	}
+}
+
+func TestGenerateJSON(t *testing.T) {
+	data, err := LoaderFor(&GenerateConfig{}).Build().GenerateJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{
+  "Str": "str-def",
+  "Int": 8080,
+  "Sub": {
+    "Dur": "1h2m3s",
+    "Slice": [
+      1,
+      2,
+      3
+    ]
+  },
+  "Em": "em-def"
+}
+`
+	if got := string(data); got != want {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+type GenerateJSONConfig struct {
+	MaxConns int               `json:"max_conns" default:"10"`
+	Name     string            `json:"name,omitempty"`
+	Secret   string            `json:"-" default:"secret"`
+	Addr     string            `json:"addr" default:"${Host}:80"`
+	Host     string            `json:"host" default:"localhost"`
+	Labels   map[string]string `json:"labels" default:"team:core"`
+	DB       struct {
+		Timeout time.Duration  `json:"timeout" default:"5s"`
+		Retry   *time.Duration `json:"retry" default:"1m"`
+		Params  []string       `json:"params" default:"a,b"`
+	} `json:"db"`
+	Inlined
+	Embedded `json:"embedded"`
+}
+
+func TestGenerateJSON_Tags(t *testing.T) {
+	data, err := LoaderFor(&GenerateJSONConfig{}).IncludeZeroDefaults().Build().GenerateJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{
+  "max_conns": 10,
+  "host": "localhost",
+  "labels": {
+    "team": "core"
+  },
+  "db": {
+    "timeout": "5s",
+    "retry": "1m0s",
+    "params": [
+      "a",
+      "b"
+    ]
+  },
+  "Level": "info",
+  "embedded": {
+    "Retries": 3
+  }
+}
+`
+	if got := string(data); got != want {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestGenerateJSON_RoundTrip(t *testing.T) {
+	f := func(cfg, fromDefaults, fromFile interface{}) {
+		t.Helper()
+
+		data, err := LoaderFor(cfg).SkipEnvironment().SkipFlags().Build().GenerateJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = LoaderFor(fromDefaults).SkipFiles().SkipEnvironment().SkipFlags().Build().Load(fromDefaults)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		loader := LoaderFor(fromFile).SkipDefaults().SkipEnvironment().SkipFlags().Build()
+		if err := loader.LoadBytes(fromFile, data, "json"); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(fromDefaults, fromFile) {
+			t.Fatalf("want %+v, got %+v\n%s", fromDefaults, fromFile, data)
+		}
+	}
+
+	f(&GenerateConfig{}, &GenerateConfig{}, &GenerateConfig{})
+
+	type Config struct {
+		MaxConns int               `json:"max_conns" default:"10"`
+		Host     string            `json:"host" default:"localhost"`
+		Labels   map[string]string `json:"labels" default:"team:core"`
+		DB       struct {
+			Timeout time.Duration  `json:"timeout" default:"5s"`
+			Retry   *time.Duration `json:"retry" default:"1m"`
+			Params  []string       `json:"params" default:"a,b"`
+		} `json:"db"`
+		Inlined
+		Embedded `json:"embedded"`
+	}
+	f(&Config{}, &Config{}, &Config{})
+}
+
+func TestSplitWords(t *testing.T) {
+	f := func(name string, want []string) {
+		t.Helper()
+
+		if got := splitWords(name); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%q: want %v, got %v", name, want, got)
+		}
+	}
+
+	f("", nil)
+	f("Port", []string{"Port"})
+	f("HTTPPort", []string{"HTTP", "Port"})
+	f("MaxConns", []string{"Max", "Conns"})
+	f("maxConns", []string{"max", "Conns"})
+	f("UserID", []string{"User", "ID"})
+	f("Version2Name", []string{"Version2", "Name"})
+	f("Port8080", []string{"Port8080"})
+	f("max_conns", []string{"max_conns"})
+}
+
+func TestSplitNameWords(t *testing.T) {
+	if got, want := splitNameWords("HTTP.HTTPPort", "-"), "HTTP.HTTP-Port"; got != want {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	f := func(a, b string, want int) {
+		t.Helper()
+
+		if got := levenshtein(a, b); got != want {
+			t.Fatalf("%q and %q: want %v, got %v", a, b, want, got)
+		}
+	}
+
+	f("", "", 0)
+	f("abc", "", 3)
+	f("", "abc", 3)
+	f("kitten", "sitting", 3)
+	f("APP_MAXCONNS", "APP_MAX_CONNS", 1)
+}
+
+func TestClosestName(t *testing.T) {
+	names := []string{"APP_PORT", "APP_MAX_CONNS", "APP_HOST"}
+
+	if got, want := closestName("APP_MAXCONNS", names), "APP_MAX_CONNS"; got != want {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	if got := closestName("APP_SOMETHING_ELSE", names); got != "" {
+		t.Fatalf("want empty, got %v", got)
+	}
+}
+
+func TestSplitTagList(t *testing.T) {
+	f := func(tag string, want []string) {
+		t.Helper()
+
+		if got := splitTagList(tag); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%q: want %v, got %v", tag, want, got)
+		}
+	}
+
+	f("", nil)
+	f("a", []string{"a"})
+	f("a, b,,c ", []string{"a", "b", "c"})
+}
+
+func TestSourceOf(t *testing.T) {
+	type SourceConfig struct {
+		Default string `default:"def"`
+		File    string `default:"def" json:"file"`
+		Env     string `default:"def" json:"env"`
+		Flag    string `default:"def" json:"flag"`
+		Tmpl    string `default:"${Default}"`
+		Unset   string
+		Nested  struct {
+			Value int `json:"value"`
+		} `json:"nested"`
+	}
+
+	os.Clearenv()
+	setEnv(t, "TST_ENV", "env")
+	setEnv(t, "TST_FLAG", "env")
+	defer os.Clearenv()
+
+	var cfg SourceConfig
+	loader := LoaderFor(&cfg).
+		WithEnvPrefix("TST").
+		Build()
+
+	if err := loader.Flags().Parse([]string{"-flag=flag"}); err != nil {
+		t.Fatal(err)
+	}
+	data := `{"file": "file", "env": "file", "nested": {"value": 1}}`
+	if err := loader.LoadBytes(&cfg, []byte(data), "json"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]Source{
+		"Default":      SourceDefault,
+		"File":         SourceFile,
+		"Env":          SourceEnv,
+		"Flag":         SourceFlag,
+		"Tmpl":         SourceDefault,
+		"Unset":        SourceUnset,
+		"Nested.Value": SourceFile,
+		"NotExists":    SourceUnset,
+	}
+	for name, src := range want {
+		if got := loader.SourceOf(name); got != src {
+			t.Errorf("%s: want %v, got %v", name, src, got)
+		}
+	}
+}
+
+func TestSourceOf_YAMLKeyCase(t *testing.T) {
+	type SourceConfig struct {
+		Port int
+		Host string
+		Sub  *struct {
+			Name string
+		}
+	}
+
+	var cfg SourceConfig
+	var set []string
+	loader := LoaderFor(&cfg).
+		SkipEnvironment().
+		SkipFlags().
+		OnFieldSet(func(fieldName, source, rawValue string) {
+			set = append(set, fieldName)
+		}).
+		Build()
+
+	// YAML decoder matches the keys exactly, so only host is decoded
+	data := "Port: 8080\nhost: h\nSub: {name: n}\n"
+	if err := loader.LoadBytes(&cfg, []byte(data), "yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Port != 0 || cfg.Host != "h" || cfg.Sub != nil {
+		t.Fatalf("want only host, got %+v", cfg)
+	}
+	if got := loader.SourceOf("Port"); got != SourceUnset {
+		t.Fatalf("want %v, got %v", SourceUnset, got)
+	}
+	if want := []string{"Host"}; !reflect.DeepEqual(want, set) {
+		t.Fatalf("want %v, got %v", want, set)
+	}
+}
+
+func TestSourceString(t *testing.T) {
+	f := func(src Source, want string) {
+		t.Helper()
+		if got := src.String(); got != want {
+			t.Fatalf("want %q, got %q", want, got)
+		}
+	}
+
+	f(SourceUnset, "unset")
+	f(SourceDefault, "default")
+	f(SourceFile, "file")
+	f(SourceEnv, "env")
+	f(SourceFlag, "flag")
+	f(SourceKV, "kv")
+	f(SourceLiteral, "literal")
+	f(SourceFile|SourceFlag, "file,flag")
+	f(Source(100), "Source(100)")
+
+	if SourceDefault != 1 || SourceFlag != 8 {
+		t.Fatal("sources must be bit flags")
+	}
+}
+
+func TestOnFieldSet(t *testing.T) {
+	type HookConfig struct {
+		Default string            `default:"def"`
+		File    int               `default:"1" json:"file"`
+		Env     string            `json:"env"`
+		Flag    bool              `json:"flag"`
+		Labels  map[string]string `json:"labels" merge:"true"`
+		Unset   string
+	}
+
+	os.Clearenv()
+	setEnv(t, "TST_ENV", "env")
+	setEnv(t, "TST_LABELS_TEAM", "core")
+	defer os.Clearenv()
+
+	var got []string
+	var cfg HookConfig
+	loader := LoaderFor(&cfg).
+		WithEnvPrefix("TST").
+		OnFieldSet(func(fieldName, source, rawValue string) {
+			got = append(got, fieldName+" "+source+" "+rawValue)
+		}).
+		Build()
+
+	if err := loader.Flags().Parse([]string{"-flag"}); err != nil {
+		t.Fatal(err)
+	}
+	data := `{"file": 2, "env": "file", "labels": {"app": "api"}}`
+	if err := loader.LoadBytes(&cfg, []byte(data), "json"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"Default default def",
+		"File default 1",
+		"File file 2",
+		"Env file file",
+		"Labels file {\"app\":\"api\"}",
+		"Env env env",
+		"Labels env team:core",
+		"Flag flag true",
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+}
+
+func TestSourceTag(t *testing.T) {
+	type SecretConfig struct {
+		Password string `json:"password" source:"file"`
+		Token    string `json:"token" default:"def" source:"env, flag"`
+		Host     string `json:"host"`
+	}
+
+	os.Clearenv()
+	setEnv(t, "TST_PASSWORD", "env")
+	setEnv(t, "TST_TOKEN", "env")
+	defer os.Clearenv()
+
+	var warnings []string
+	var cfg SecretConfig
+	loader := LoaderFor(&cfg).
+		WithEnvPrefix("TST").
+		WithLogger(func(format string, args ...interface{}) {
+			warnings = append(warnings, fmt.Sprintf(format, args...))
+		}).
+		Build()
+
+	if loader.Flags().Lookup("password") != nil {
+		t.Fatal("flag must not be defined")
+	}
+	if err := loader.Flags().Parse([]string{"-host=flag"}); err != nil {
+		t.Fatal(err)
+	}
+	data := `{"password": "file", "token": "file", "host": "file"}`
+	if err := loader.LoadBytes(&cfg, []byte(data), "json"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := SecretConfig{Password: "file", Token: "env", Host: "flag"}
+	if want != cfg {
+		t.Fatalf("want %+v, got %+v", want, cfg)
+	}
+	wantWarnings := []string{
+		`aconfig: file key "token" is ignored, field "Token" can be set only from env,flag`,
+		`aconfig: env "TST_PASSWORD" is ignored, field "Password" can be set only from file`,
+	}
+	if !reflect.DeepEqual(wantWarnings, warnings) {
+		t.Fatalf("want %q, got %q", wantWarnings, warnings)
+	}
+
+	type BadConfig struct {
+		Value string `source:"vault"`
+	}
+	err := LoaderFor(&BadConfig{}).SkipFlags().Build().Load(&BadConfig{})
+	if err == nil || !strings.Contains(err.Error(), `unknown source "vault"`) {
+		t.Fatalf("must be an error, got %v", err)
+	}
+}
+
+func TestWithSources(t *testing.T) {
+	all := SourceDefault | SourceFile | SourceEnv | SourceFlag | SourceKV
+
+	f := func(loader *Loader, want Source) {
+		t.Helper()
+
+		if got := loader.Sources(); got != want {
+			t.Fatalf("want %v, got %v", want, got)
+		}
+	}
+
+	f(LoaderFor(&TestConfig{}), all)
+	f(LoaderFor(&TestConfig{}).SkipFiles().SkipKV(), SourceDefault|SourceEnv|SourceFlag)
+	f(LoaderFor(&TestConfig{}).WithSources(SourceEnv|SourceFlag), SourceEnv|SourceFlag)
+	f(LoaderFor(&TestConfig{}).WithSources(SourceEnv|SourceFlag).SkipFlags(), SourceEnv)
+	f(LoaderFor(&TestConfig{}).SkipDefaults().WithSources(all), all)
+
+	setEnv(t, "TST_STR", "str-env")
+	defer os.Clearenv()
+
+	var cfg TestConfig
+	loader := LoaderFor(&cfg).
+		WithSources(SourceEnv | SourceFlag).
+		WithEnvPrefix("TST").
+		WithFiles([]string{"testdata/config1.json"}).
+		Build()
+
+	if loader.Flags().Lookup("int") == nil {
+		t.Fatal("flag must be defined")
+	}
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if want := (TestConfig{Str: "str-env"}); !reflect.DeepEqual(want, cfg) {
+		t.Fatalf("want %+v, got %+v", want, cfg)
+	}
+}
+
+func TestLoadKV(t *testing.T) {
+	type KVConfig struct {
+		Str  string `default:"def"`
+		Int  int
+		Name string `kv:"custom/name"`
+		Env  string
+		HTTP struct {
+			Port int
+		}
+	}
+
+	os.Clearenv()
+	setEnv(t, "TST_ENV", "env")
+	defer os.Clearenv()
+
+	kv := MapKVSource{
+		"app/Str":         "kv",
+		"app/Int":         "42",
+		"app/custom/name": "name",
+		"app/Env":         "kv",
+		"app/HTTP/Port":   "8080",
+	}
+
+	var cfg KVConfig
+	loader := LoaderFor(&cfg).
+		SkipFiles().
+		SkipFlags().
+		WithEnvPrefix("TST").
+		WithKVSource(kv, "app/").
+		Build()
+
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Str != "kv" || cfg.Int != 42 || cfg.Name != "name" || cfg.Env != "env" || cfg.HTTP.Port != 8080 {
+		t.Fatalf("got %+v", cfg)
+	}
+	if got := loader.SourceOf("HTTP.Port"); got != SourceKV {
+		t.Fatalf("want %v, got %v", SourceKV, got)
+	}
+
+	cfg = KVConfig{}
+	if err := loader.LoadWith(&cfg, WithoutKV()); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Str != "def" || cfg.Int != 0 {
+		t.Fatalf("got %+v", cfg)
+	}
+}
+
+type errKVSource struct{}
+
+func (errKVSource) Get(ctx context.Context, key string) (string, bool, error) {
+	return "", false, errors.New("connection refused")
+}
+
+// blockingKVSource waits for the context like a remote store which doesn't respond.
+type blockingKVSource struct{}
+
+func (blockingKVSource) Get(ctx context.Context, key string) (string, bool, error) {
+	<-ctx.Done()
+	return "", false, ctx.Err()
+}
+
+func TestLoadKV_Errors(t *testing.T) {
+	type KVConfig struct {
+		Int int
+	}
+
+	f := func(ctx context.Context, src KVSource) {
+		t.Helper()
+
+		var cfg KVConfig
+		loader := LoaderFor(&cfg).
+			SkipDefaults().
+			SkipFiles().
+			SkipEnvironment().
+			SkipFlags().
+			WithKVSource(src, "").
+			Build()
+
+		if err := loader.LoadContext(ctx, &cfg); err == nil {
+			t.Fatal("must be an error")
+		}
+	}
+
+	f(context.Background(), errKVSource{})
+	f(context.Background(), MapKVSource{"Int": "abc"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f(ctx, MapKVSource{"Int": "1"})
+}
+
+func TestLoadKV_Timeout(t *testing.T) {
+	type KVConfig struct {
+		Int int
+	}
+
+	var cfg KVConfig
+	loader := LoaderFor(&cfg).
+		SkipDefaults().
+		SkipFiles().
+		SkipEnvironment().
+		SkipFlags().
+		WithKVSource(blockingKVSource{}, "").
+		Build()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := loader.LoadContext(ctx, &cfg)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+type StorageBackend interface {
+	Kind() string
+}
+
+type S3Storage struct {
+	Bucket  string        `json:"bucket" yaml:"bucket" toml:"bucket"`
+	Timeout time.Duration `json:"timeout" yaml:"timeout" toml:"timeout" default:"1s"`
+}
+
+func (*S3Storage) Kind() string { return "s3" }
+
+type DiskStorage struct {
+	Path string `json:"path" yaml:"path" toml:"path" default:"/tmp"`
+}
+
+func (*DiskStorage) Kind() string { return "disk" }
+
+type ImplConfig struct {
+	Storage struct {
+		Type    string         `json:"type" yaml:"type" toml:"type"`
+		Backend StorageBackend `json:"backend" yaml:"backend" toml:"backend" impl:"Type"`
+	} `json:"storage" yaml:"storage" toml:"storage"`
+}
+
+func newImplLoader(cfg *ImplConfig) *Loader {
+	return LoaderFor(cfg).
+		SkipFlags().
+		WithEnvPrefix("TST").
+		RegisterImpl("s3", func() interface{} { return &S3Storage{} }).
+		RegisterImpl("disk", func() interface{} { return &DiskStorage{} }).
+		Build()
+}
+
+func TestLoadImpl_File(t *testing.T) {
+	f := func(file string) {
+		t.Helper()
+
+		var cfg ImplConfig
+		loader := newImplLoader(&cfg)
+		if err := loader.LoadWithFile(&cfg, file); err != nil {
+			t.Fatal(err)
+		}
+
+		s3, ok := cfg.Storage.Backend.(*S3Storage)
+		if !ok {
+			t.Fatalf("want *S3Storage, got %T", cfg.Storage.Backend)
+		}
+		if s3.Bucket != "configs" || s3.Timeout != 5*time.Second {
+			t.Fatalf("got %+v", s3)
+		}
+	}
+
+	f("testdata/impl_config.json")
+	f("testdata/impl_config.yaml")
+	f("testdata/impl_config.toml")
+}
+
+func TestLoadImpl_Env(t *testing.T) {
+	os.Clearenv()
+	setEnv(t, "TST_STORAGE_TYPE", "disk")
+	setEnv(t, "TST_STORAGE_BACKEND_PATH", "/var/data")
+	defer os.Clearenv()
+
+	var cfg ImplConfig
+	loader := newImplLoader(&cfg)
+	if err := loader.LoadWith(&cfg, WithoutFiles()); err != nil {
+		t.Fatal(err)
+	}
+
+	disk, ok := cfg.Storage.Backend.(*DiskStorage)
+	if !ok {
+		t.Fatalf("want *DiskStorage, got %T", cfg.Storage.Backend)
+	}
+	if disk.Path != "/var/data" {
+		t.Fatalf("got %+v", disk)
+	}
+
+	os.Clearenv()
+	setEnv(t, "TST_STORAGE_TYPE", "disk")
+	cfg = ImplConfig{}
+	if err := loader.LoadWith(&cfg, WithoutFiles()); err != nil {
+		t.Fatal(err)
+	}
+	if disk := cfg.Storage.Backend.(*DiskStorage); disk.Path != "/tmp" {
+		t.Fatalf("want default, got %+v", disk)
+	}
+
+	os.Clearenv()
+	cfg = ImplConfig{}
+	if err := loader.LoadWith(&cfg, WithoutFiles()); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Storage.Backend != nil {
+		t.Fatalf("want nil, got %T", cfg.Storage.Backend)
+	}
+}
+
+func TestLoadImpl_Errors(t *testing.T) {
+	f := func(cfg interface{}, env, want string) {
+		t.Helper()
+
+		os.Clearenv()
+		setEnv(t, "TST_TYPE", env)
+		defer os.Clearenv()
+
+		loader := LoaderFor(cfg).
+			SkipFiles().
+			SkipFlags().
+			WithEnvPrefix("TST").
+			RegisterImpl("s3", func() interface{} { return &S3Storage{} }).
+			RegisterImpl("value", func() interface{} { return S3Storage{} }).
+			RegisterImpl("other", func() interface{} { return &struct{}{} }).
+			Build()
+
+		err := loader.Load(cfg)
+		if err == nil {
+			t.Fatal("must be an error")
+		}
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("want %q in %q", want, err.Error())
+		}
+	}
+
+	type Config struct {
+		Type    string
+		Backend StorageBackend `impl:"Type"`
+	}
+	f(&Config{}, "gcs", `unknown implementation "gcs" of field "Backend"`)
+	f(&Config{}, "value", `must be a pointer to a struct`)
+	f(&Config{}, "other", `doesn't implement aconfig.StorageBackend`)
+
+	f(&struct {
+		Type    string
+		Backend StorageBackend `impl:"Kind"`
+	}{}, "s3", `unknown field "Kind" in impl tag of field "Backend"`)
+
+	f(&struct {
+		Type StorageBackend
+	}{}, "s3", `field "Type" has interface type aconfig.StorageBackend, register implementations with RegisterImpl`)
+}
+
+type PrefixConfig struct {
+	Name     string `json:"name" default:"app"`
+	Database struct {
+		Host string `json:"host" default:"localhost"`
+		Port int    `json:"port"`
+		User string `json:"user"`
+	} `json:"database"`
+	DatabaseURL string `json:"database_url"`
+	Server      struct {
+		Port int `json:"port"`
+	} `json:"server"`
+}
+
+func (c *PrefixConfig) SetDefaults() {
+	c.Server.Port = 80
+	c.Database.User = "admin"
+}
+
+func TestLoadPrefix(t *testing.T) {
+	os.Clearenv()
+	setEnv(t, "TST_DATABASE_PORT", "5432")
+	setEnv(t, "TST_SERVER_PORT", "8080")
+	defer os.Clearenv()
+
+	f := func(prefix, file string, want PrefixConfig) {
+		t.Helper()
+
+		cfg := PrefixConfig{Name: "old", DatabaseURL: "old-url"}
+		cfg.Database.Host = "old-host"
+		cfg.Server.Port = 1
+
+		loader := LoaderFor(&cfg).
+			SkipFlags().
+			WithEnvPrefix("TST").
+			WithFiles([]string{file}).
+			Build()
+
+		if err := loader.LoadPrefix(&cfg, prefix); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(want, cfg) {
+			t.Fatalf("want %+v, got %+v", want, cfg)
+		}
+	}
+
+	want := PrefixConfig{Name: "old", DatabaseURL: "old-url"}
+	want.Database.Host = "localhost"
+	want.Database.Port = 5432
+	want.Database.User = "admin"
+	want.Server.Port = 1
+	f("Database", "testdata/not_exists.json", want)
+	f("Database.", "testdata/not_exists.json", want)
+
+	want.Database.Host = "db"
+	f("Database", "testdata/prefix_config.json", want)
+
+	want = PrefixConfig{Name: "old", DatabaseURL: "old-url"}
+	want.Database.Host = "old-host"
+	want.Server.Port = 8080
+	f("Server", "testdata/prefix_config.json", want)
+}
+
+func TestGetters(t *testing.T) {
+	type GetterConfig struct {
+		Name string
+		Port int
+		TLS  *struct {
+			Enabled bool
+		}
+		Timeouts map[string]int
+		Extra    map[string]interface{} `aconfig:",remain"`
+	}
+
+	var cfg GetterConfig
+	loader := LoaderFor(&cfg).
+		SkipDefaults().
+		SkipEnvironment().
+		SkipFlags().
+		Build()
+
+	data := `{
+		"Name": "app",
+		"Port": 8080,
+		"Timeouts": {"read": 5},
+		"plugin": {"name": "auth", "retries": 3, "ratio": 0.5, "enabled": true, "big": 9223372036854775808, "min": -9223372036854775808}
+	}`
+	if err := loader.LoadBytes(&cfg, []byte(data), "json"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, ok := loader.GetString("Name"); !ok || got != "app" {
+		t.Fatalf("got %q %v", got, ok)
+	}
+	if got, ok := loader.GetInt("Port"); !ok || got != 8080 {
+		t.Fatalf("got %v %v", got, ok)
+	}
+	if got, ok := loader.GetInt("Timeouts.read"); !ok || got != 5 {
+		t.Fatalf("got %v %v", got, ok)
+	}
+	if got, ok := loader.GetString("plugin.name"); !ok || got != "auth" {
+		t.Fatalf("got %q %v", got, ok)
+	}
+	if got, ok := loader.GetString("Extra.plugin.name"); !ok || got != "auth" {
+		t.Fatalf("got %q %v", got, ok)
+	}
+	if got, ok := loader.GetInt("plugin.retries"); !ok || got != 3 {
+		t.Fatalf("got %v %v", got, ok)
+	}
+	if got, ok := loader.GetBool("plugin.enabled"); !ok || !got {
+		t.Fatalf("got %v %v", got, ok)
+	}
+	if got, ok := loader.GetInt("plugin.min"); !ok || got != math.MinInt64 {
+		t.Fatalf("got %v %v", got, ok)
+	}
+
+	f := func(ok bool) {
+		t.Helper()
+		if ok {
+			t.Fatal("must be false")
+		}
+	}
+	_, ok := loader.GetInt("plugin.ratio")
+	f(ok)
+	_, ok = loader.GetInt("plugin.big")
+	f(ok)
+	_, ok = loader.GetInt("Name")
+	f(ok)
+	_, ok = loader.GetString("Port")
+	f(ok)
+	_, ok = loader.GetBool("TLS.Enabled")
+	f(ok)
+	_, ok = loader.GetString("plugin.name.first")
+	f(ok)
+	_, ok = loader.GetString("Unknown")
+	f(ok)
+	_, ok = loader.GetString("Timeouts.write")
+	f(ok)
+}
+
+func TestTransform(t *testing.T) {
+	type TransformConfig struct {
+		Level  string            `json:"level" transform:"trim,lower" oneof:"debug,info"`
+		Host   string            `transform:"upper" default:"localhost"`
+		Name   *string           `transform:"title"`
+		Tags   []string          `transform:"trim,upper"`
+		Labels map[string]string `transform:"lower"`
+		Plain  string
+	}
+
+	os.Clearenv()
+	setEnv(t, "TST_NAME", "my app-2go")
+	setEnv(t, "TST_TAGS", " a , b")
+	setEnv(t, "TST_LABELS", "team:CORE")
+	setEnv(t, "TST_PLAIN", " As Is ")
+	defer os.Clearenv()
+
+	var cfg TransformConfig
+	loader := LoaderFor(&cfg).
+		SkipFlags().
+		WithEnvPrefix("TST").
+		Build()
+
+	if err := loader.LoadBytes(&cfg, []byte(`{"level": "  INFO "}`), "json"); err != nil {
+		t.Fatal(err)
+	}
+
+	name := "My App-2go"
+	want := TransformConfig{
+		Level:  "info",
+		Host:   "LOCALHOST",
+		Name:   &name,
+		Tags:   []string{"A", "B"},
+		Labels: map[string]string{"team": "core"},
+		Plain:  " As Is ",
+	}
+	if !reflect.DeepEqual(want, cfg) {
+		t.Fatalf("want %+v, got %+v", want, cfg)
+	}
+}
+
+func TestTransform_Unknown(t *testing.T) {
+	cfg := struct {
+		Level string `transform:"lower,snake"`
+	}{}
+
+	loader := LoaderFor(&cfg).
+		SkipFiles().
+		SkipEnvironment().
+		SkipFlags().
+		Build()
+
+	if err := loader.Load(&cfg); err == nil {
+		t.Fatal("must be an error")
+	}
+}
+
+type CachedConfig struct {
+	EmbeddedConfig
+
+	Str    string `default:"str"`
+	Nested struct {
+		Int int `default:"1"`
+	}
+	Ptr *struct {
+		Value string
+	}
+	Unset *struct {
+		Value string
+	}
+}
+
+func TestLayoutCache(t *testing.T) {
+	os.Clearenv()
+	defer os.Clearenv()
+
+	loader := LoaderFor(&CachedConfig{}).
+		SkipFiles().
+		SkipFlags().
+		WithEnvPrefix("TST").
+		Build()
+
+	f := func(value string) {
+		t.Helper()
+
+		setEnv(t, "TST_STR", value)
+		setEnv(t, "TST_NESTED_INT", "2")
+		setEnv(t, "TST_PTR_VALUE", value)
+		setEnv(t, "TST_EM", value)
+
+		var cfg CachedConfig
+		if err := loader.Load(&cfg); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Str != value || cfg.Em != value || cfg.Nested.Int != 2 {
+			t.Fatalf("got %+v", cfg)
+		}
+		if cfg.Ptr == nil || cfg.Ptr.Value != value {
+			t.Fatalf("want %q, got %+v", value, cfg.Ptr)
+		}
+		if cfg.Unset != nil {
+			t.Fatalf("want nil, got %+v", cfg.Unset)
+		}
+		if got := loader.SourceOf("Ptr.Value"); got != SourceEnv {
+			t.Fatalf("want %v, got %v", SourceEnv, got)
+		}
+	}
+
+	f("first")
+	f("second")
+
+	if len(loader.layouts) != 1 {
+		t.Fatalf("want 1 cached layout, got %d", len(loader.layouts))
+	}
+}
+
+func BenchmarkGetFields(b *testing.B) {
+	loader := LoaderFor(&CachedConfig{}).Build()
+
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			loader.getFields(&CachedConfig{})
+		}
+	})
+
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			loader.layouts = nil
+			loader.getFields(&CachedConfig{})
+		}
+	})
+}
+
+func TestIgnoreCase(t *testing.T) {
+	type Level string
+
+	type CaseConfig struct {
+		Level  Level             `json:"level" oneof:"debug,Info,warn"`
+		Limits map[string]int    `json:"limits" merge:"true"`
+		Labels map[Level]string  `json:"labels"`
+		Other  *map[string]int   `json:"other"`
+		Ints   map[int]string    `json:"ints"`
+		Plain  string            `json:"plain"`
+		Kept   map[string]string `json:"kept"`
+	}
+
+	os.Clearenv()
+	setEnv(t, "TST_LIMITS_CPU", "2")
+	defer os.Clearenv()
+
+	var cfg CaseConfig
+	loader := LoaderFor(&cfg).
+		SkipFlags().
+		WithEnvPrefix("TST").
+		IgnoreCase().
+		Build()
+
+	data := `{
+		"level": "INFO",
+		"limits": {"Memory": 1},
+		"labels": {"Team": "core"},
+		"other": {"A": 1},
+		"ints": {"1": "one"},
+		"plain": "As Is"
+	}`
+	if err := loader.LoadBytes(&cfg, []byte(data), "json"); err != nil {
+		t.Fatal(err)
+	}
+
+	other := map[string]int{"a": 1}
+	want := CaseConfig{
+		Level:  "Info",
+		Limits: map[string]int{"memory": 1, "cpu": 2},
+		Labels: map[Level]string{"team": "core"},
+		Other:  &other,
+		Ints:   map[int]string{1: "one"},
+		Plain:  "As Is",
+	}
+	if !reflect.DeepEqual(want, cfg) {
+		t.Fatalf("want %+v, got %+v", want, cfg)
+	}
+
+	data = `{"level": "fatal"}`
+	if err := loader.LoadBytes(&CaseConfig{}, []byte(data), "json"); err == nil {
+		t.Fatal("must be an error")
+	}
+
+	data = `{"level": "debug", "kept": {"Team": "a", "TEAM": "b"}}`
+	err := loader.LoadBytes(&CaseConfig{}, []byte(data), "json")
+	if err == nil || !strings.Contains(err.Error(), "differ only in case") {
+		t.Fatalf("must be an error, got %v", err)
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	type SizeConfig struct {
+		Buffer  int      `json:"buffer" bytesize:"true"`
+		Cache   uint64   `json:"cache" bytesize:"true"`
+		Default int32    `default:"1.5KiB" bytesize:"true"`
+		Limit   *int64   `bytesize:"true"`
+		Chunks  []uint32 `bytesize:"true"`
+		Plain   int      `json:"plain"`
+	}
+
+	os.Clearenv()
+	setEnv(t, "TST_LIMIT", "2 GB")
+	setEnv(t, "TST_CHUNKS", "1kb,4KiB")
+	defer os.Clearenv()
+
+	var cfg SizeConfig
+	loader := LoaderFor(&cfg).
+		SkipFlags().
+		WithEnvPrefix("TST").
+		Build()
+
+	data := `{"buffer": "10MB", "cache": "512MiB", "plain": 42}`
+	if err := loader.LoadBytes(&cfg, []byte(data), "json"); err != nil {
+		t.Fatal(err)
+	}
+
+	limit := int64(2e9)
+	want := SizeConfig{
+		Buffer:  10e6,
+		Cache:   512 << 20,
+		Default: 1536,
+		Limit:   &limit,
+		Chunks:  []uint32{1000, 4096},
+		Plain:   42,
+	}
+	if !reflect.DeepEqual(want, cfg) {
+		t.Fatalf("want %+v, got %+v", want, cfg)
+	}
+	if got := loader.SourceOf("Buffer"); got != SourceFile {
+		t.Fatalf("want %v, got %v", SourceFile, got)
+	}
+}
+
+func TestByteSize_PlainNumbers(t *testing.T) {
+	type SizeConfig struct {
+		Signed   int64  `json:"signed" bytesize:"true"`
+		Unsigned uint64 `bytesize:"true"`
+		File     uint64 `json:"file" bytesize:"true"`
+	}
+
+	os.Clearenv()
+	setEnv(t, "TST_UNSIGNED", "18446744073709551615")
+	defer os.Clearenv()
+
+	var cfg SizeConfig
+	loader := LoaderFor(&cfg).
+		SkipFlags().
+		WithEnvPrefix("TST").
+		Build()
+
+	data := `{"signed": "9007199254740993", "file": 9007199254740993}`
+	if err := loader.LoadBytes(&cfg, []byte(data), "json"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := SizeConfig{
+		Signed:   9007199254740993,
+		Unsigned: 18446744073709551615,
+		File:     9007199254740993,
+	}
+	if !reflect.DeepEqual(want, cfg) {
+		t.Fatalf("want %+v, got %+v", want, cfg)
+	}
+}
+
+func TestByteSize_Errors(t *testing.T) {
+	type SizeConfig struct {
+		Small    uint8  `bytesize:"true"`
+		Signed   int64  `bytesize:"true"`
+		Unsigned uint64 `bytesize:"true"`
+	}
+
+	f := func(env, value, wantErr string) {
+		t.Helper()
+
+		var cfg SizeConfig
+		loader := LoaderFor(&cfg).
+			SkipFlags().
+			SkipFiles().
+			WithEnvPrefix("TST").
+			Build()
+
+		setEnv(t, env, value)
+		defer os.Clearenv()
+
+		err := loader.Load(&cfg)
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Fatalf("want error with %q, got %v", wantErr, err)
+		}
+	}
+
+	f("TST_SMALL", "10XB", `unknown unit "XB"`)
+	f("TST_SMALL", "MB", "want a value like 10MB")
+	f("TST_SMALL", "1KB", "value out of range")
+	f("TST_SMALL", "-1B", "value out of range")
+	f("TST_SIGNED", "8192PiB", "value out of range")
+	f("TST_UNSIGNED", "16384PiB", "value out of range")
+}
+
+func TestPercent(t *testing.T) {
+	type PercentConfig struct {
+		CPULimit float64   `json:"cpu_limit" percent:"true"`
+		Sampling float32   `json:"sampling" percent:"true"`
+		Default  float64   `default:"12.5%" percent:"true"`
+		Steps    []float64 `percent:"true"`
+		Plain    float64   `json:"plain"`
+	}
+
+	os.Clearenv()
+	setEnv(t, "TST_STEPS", "10%, 0.5,100%")
+	defer os.Clearenv()
+
+	var cfg PercentConfig
+	loader := LoaderFor(&cfg).
+		SkipFlags().
+		WithEnvPrefix("TST").
+		Build()
+
+	data := `{"cpu_limit": "75%", "sampling": 0.25, "plain": 75}`
+	if err := loader.LoadBytes(&cfg, []byte(data), "json"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := PercentConfig{
+		CPULimit: 0.75,
+		Sampling: 0.25,
+		Default:  0.125,
+		Steps:    []float64{0.1, 0.5, 1},
+		Plain:    75,
+	}
+	if !reflect.DeepEqual(want, cfg) {
+		t.Fatalf("want %+v, got %+v", want, cfg)
+	}
+}
+
+func TestPercent_Errors(t *testing.T) {
+	type PercentConfig struct {
+		Value float64 `percent:"true"`
+	}
+
+	f := func(value, wantErr string) {
+		t.Helper()
+
+		var cfg PercentConfig
+		loader := LoaderFor(&cfg).
+			SkipFlags().
+			SkipFiles().
+			WithEnvPrefix("TST").
+			Build()
+
+		setEnv(t, "TST_VALUE", value)
+		defer os.Clearenv()
+
+		err := loader.Load(&cfg)
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Fatalf("want error with %q, got %v", wantErr, err)
+		}
+	}
+
+	f("abc%", "want a value like 75% or 0.75")
+	f("150%", "must be from 0% to 100%")
+	f("-1%", "must be from 0% to 100%")
+	f("1.5", "must be from 0 to 1")
+	f("NaN", "want a value like")
+}
+
+type NullConfig struct {
+	Host    string            `default:"localhost" json:"host" yaml:"host"`
+	Port    *int              `default:"8080" json:"port" yaml:"port"`
+	Tags    []string          `default:"a,b" json:"tags" yaml:"tags"`
+	Limit   float64           `default:"1.5" json:"limit" yaml:"limit"`
+	Timeout string            `default:"5s" json:"timeout" yaml:"timeout"`
+	Labels  map[string]string `default:"env:dev" json:"labels" yaml:"labels"`
+	DB      struct {
+		User string `default:"admin" json:"user" yaml:"user"`
+		Name string `default:"app" json:"name" yaml:"name"`
+	} `json:"db" yaml:"db"`
+}
+
+func TestNullClearsField(t *testing.T) {
+	f := func(loader *Loader, data, ext string) {
+		t.Helper()
+
+		var cfg NullConfig
+		if err := loader.LoadBytes(&cfg, []byte(data), ext); err != nil {
+			t.Fatal(err)
+		}
+
+		want := NullConfig{
+			Host:    "",
+			Port:    nil,
+			Tags:    nil,
+			Limit:   0,
+			Timeout: "5s",
+			Labels:  map[string]string{"env": "dev"},
+		}
+		if !reflect.DeepEqual(want, cfg) {
+			t.Fatalf("want %+v, got %+v", want, cfg)
+		}
+		if got := loader.SourceOf("Port"); got != SourceFile {
+			t.Fatalf("want %v, got %v", SourceFile, got)
+		}
+	}
+
+	newLoader := func() *Loader {
+		return LoaderFor(&NullConfig{}).
+			SkipFlags().
+			SkipEnvironment().
+			WithClearToken("~unset").
+			Build()
+	}
+
+	f(newLoader(), `{"host": "~unset", "port": null, "tags": null, "limit": "~unset", "db": "~unset"}`, "json")
+	f(newLoader(), "host: ~unset\nport: null\ntags:\nlimit: ~unset\ndb: ~unset\n", "yaml")
+	f(newLoader().CaseInsensitiveKeys(), `{"HOST": "~unset", "Port": null, "tags": null, "limit": "~unset", "DB.user": "~unset", "db": {"name": "~unset"}}`, "json")
+}
+
+func TestNullKeepsScalars(t *testing.T) {
+	f := func(loader *Loader) {
+		t.Helper()
+
+		var cfg NullConfig
+		data := `{"host": null, "limit": null, "db": {"user": null}}`
+		if err := loader.LoadBytes(&cfg, []byte(data), "json"); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Host != "localhost" || cfg.Limit != 1.5 || cfg.DB.User != "admin" {
+			t.Fatalf("want defaults, got %+v", cfg)
+		}
+	}
+
+	f(LoaderFor(&NullConfig{}).SkipFlags().SkipEnvironment().Build())
+	f(LoaderFor(&NullConfig{}).SkipFlags().SkipEnvironment().CaseInsensitiveKeys().Build())
+}
+
+func TestNullClearsField_WithoutToken(t *testing.T) {
+	loader := LoaderFor(&NullConfig{}).
+		SkipFlags().
+		SkipEnvironment().
+		Build()
+
+	var cfg NullConfig
+	if err := loader.LoadBytes(&cfg, []byte(`{"host": "~unset"}`), "json"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Host != "~unset" {
+		t.Fatalf("want %q, got %q", "~unset", cfg.Host)
+	}
+}
+
+func TestFileDiscovery(t *testing.T) {
+	type DiscoveryConfig struct {
+		Str string `default:"str-def" json:"str" yaml:"str" toml:"str"`
+	}
+
+	dir, err := ioutil.TempDir("", "aconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+	writeFile(t, filepath.Join(first, "app.toml"), `str = "first-toml"`)
+	writeFile(t, filepath.Join(second, "app.json"), `{"str": "second-json"}`)
+	writeFile(t, filepath.Join(second, "app.yaml"), `str: second-yaml`)
+
+	f := func(baseName string, dirs []string, want string) {
+		t.Helper()
+
+		var cfg DiscoveryConfig
+		loader := LoaderFor(&cfg).
+			SkipEnvironment().
+			SkipFlags().
+			WithFileDiscovery(baseName, dirs...).
+			Build()
+
+		if err := loader.Load(&cfg); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Str != want {
+			t.Fatalf("want %q, got %q", want, cfg.Str)
+		}
+	}
+
+	f("app", []string{first, second}, "first-toml")
+	f("app", []string{second, first}, "second-json")
+	f("app", []string{filepath.Join(dir, "missing"), second}, "second-json")
+	f("other", []string{first, second}, "str-def")
+}
+
+func writeFile(t *testing.T, file, data string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnvPrefixCollect(t *testing.T) {
+	type CollectConfig struct {
+		Features map[string]string `env_prefix_collect:"FEATURE_"`
+		Labels   map[string]string `env_prefix_collect:"LABEL_, keep" default:"team:core" merge:"true"`
+		Limits   map[string]int    `env_prefix_collect:"LIMIT_"`
+	}
+
+	env := map[string]string{
+		"APP_FEATURE_X":     "on",
+		"APP_FEATURE_Y":     "off",
+		"OLD_FEATURE_Y":     "old",
+		"OLD_FEATURE_Z":     "old",
+		"APP_LABEL_Region":  "eu",
+		"APP_LIMIT_CPU":     "2",
+		"APP_FEATURE_":      "skipped",
+		"OTHER_FEATURE_ABC": "skipped",
+	}
+
+	var cfg CollectConfig
+	loader := LoaderFor(&cfg).
+		SkipFiles().
+		SkipFlags().
+		WithEnvPrefixes("APP", "OLD").
+		WithEnv(env).
+		FailOnUnknownEnv().
+		Build()
+
+	if err := loader.Load(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	want := CollectConfig{
+		Features: map[string]string{"x": "on", "y": "off", "z": "old"},
+		Labels:   map[string]string{"team": "core", "Region": "eu"},
+		Limits:   map[string]int{"cpu": 2},
+	}
+	if !reflect.DeepEqual(want, cfg) {
+		t.Fatalf("want %+v, got %+v", want, cfg)
+	}
+}
+
+func TestEnvPrefixCollect_Errors(t *testing.T) {
+	f := func(cfg interface{}, wantErr string) {
+		t.Helper()
+
+		loader := LoaderFor(cfg).
+			SkipFiles().
+			SkipFlags().
+			WithEnvPrefix("APP").
+			WithEnv(map[string]string{"APP_X_A": "abc"}).
+			Build()
+
+		err := loader.Load(cfg)
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Fatalf("want error with %q, got %v", wantErr, err)
+		}
+	}
+
+	f(&struct {
+		Value string `env_prefix_collect:"X_"`
+	}{}, "requires a map with string keys")
+	f(&struct {
+		Value map[string]string `env_prefix_collect:"X_,upper"`
+	}{}, `unknown option "upper"`)
+	f(&struct {
+		Value map[string]int `env_prefix_collect:"X_"`
+	}{}, `incorrect value "abc" of key "a"`)
+}
+
+type lintCommon struct {
+	Value string
+}
+
+type LintConfig struct {
+	lintCommon
+
+	Host     string
+	Callback func()
+	Events   chan string
+	Handlers map[string]func()
+	Hidden   struct {
+		value string
+	}
+	Nested struct {
+		Port int
+		Done chan struct{}
+	}
+	Ptr *struct {
+		Fn func()
+	}
+	Token  string                 `source:"flag"`
+	Secret string                 `source:"file,env"`
+	Extra  map[string]interface{} `aconfig:",remain"`
+	secret string
+}
+
+func TestLint(t *testing.T) {
+	loader := LoaderFor(&LintConfig{}).
+		SkipFlags().
+		Build()
+
+	var got []string
+	for _, err := range loader.Lint() {
+		got = append(got, err.Error())
+	}
+
+	want := []string{
+		"fields of unexported embedded aconfig.lintCommon are skipped, export it",
+		`field "Callback" of type func() cannot be set by any source`,
+		`field "Events" of type chan string cannot be set by any source`,
+		`field "Handlers" of type map[string]func() cannot be set by any source`,
+		`field "Hidden" of type struct { value string } has no fields to set`,
+		`field "Nested.Done" of type chan struct {} cannot be set by any source`,
+		`field "Ptr.Fn" of type func() cannot be set by any source`,
+		`field "Ptr" of type *struct { Fn func() } has no fields to set`,
+		`field "Token" can be set only from flag, but the loader skips it`,
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("want %q, got %q", want, got)
+	}
+
+	type CleanConfig struct {
+		Host string
+		Sub  struct {
+			Port int
+		}
+	}
+	if errs := LoaderFor(&CleanConfig{}).Build().Lint(); len(errs) != 0 {
+		t.Fatalf("want no errors, got %v", errs)
+	}
+}
+
+type StrictConfig struct {
+	HTTPPort int               `json:"http_port" yaml:"port" toml:"http-port"`
+	Labels   map[string]string `json:"labels" yaml:"labels" toml:"labels"`
+	Skipped  string            `json:"-" yaml:"-" toml:"-"`
+	Plain    string
+	DB       struct {
+		User string `json:"user" yaml:"user" toml:"user"`
+	} `json:"database" yaml:"db" toml:"database"`
+	Plugins struct {
+		Name  string                 `json:"name" yaml:"name" toml:"name"`
+		Extra map[string]interface{} `aconfig:",remain"`
+	} `json:"plugins" yaml:"plugins" toml:"plugins"`
+}
+
+func TestFailOnUnknownFileKeys(t *testing.T) {
+	f := func(data, ext, wantErr string) {
+		t.Helper()
+
+		var cfg StrictConfig
+		loader := LoaderFor(&cfg).
+			SkipEnvironment().
+			SkipFlags().
+			FailOnUnknownFileKeys().
+			Build()
+
+		err := loader.LoadBytes(&cfg, []byte(data), ext)
+		switch {
+		case wantErr == "" && err != nil:
+			t.Fatal(err)
+		case wantErr != "" && (err == nil || !strings.Contains(err.Error(), wantErr)):
+			t.Fatalf("want error with %q, got %v", wantErr, err)
+		}
+	}
+
+	f(`{"http_port": 1, "labels": {"any": "a"}, "plain": "a", "database": {"user": "u"}, "plugins": {"name": "p", "x": 1}}`, "json", "")
+	f("port: 1\nlabels: {any: a}\nplain: a\ndb: {user: u}\nplugins: {name: p, x: 1}", "yaml", "")
+	f("http-port = 1\nPlain = \"a\"\n[database]\nuser = \"u\"\n[plugins]\nname = \"p\"\nx = 1", "toml", "")
+
+	f(`{"HTTPPort": 1, "Skipped": "a", "database": {"user": "u", "pass": "p"}, "db": {}}`, "json",
+		`unknown keys "HTTPPort", "Skipped", "database.pass", "db"`)
+	f("http_port: 1\ndatabase: {user: u}", "yaml", `unknown keys "database", "http_port"`)
+	// YAML decoder matches the keys exactly, so these aren't set
+	f("Port: 1\nPlain: a\ndb: {User: u}", "yaml", `unknown keys "Plain", "Port", "db.User"`)
+	f("port = 1", "toml", `unknown keys "port"`)
+}
+
+func TestFailOnUnknownFileKeys_CaseInsensitive(t *testing.T) {
+	var cfg StrictConfig
+	loader := LoaderFor(&cfg).
+		SkipEnvironment().
+		SkipFlags().
+		CaseInsensitiveKeys().
+		FailOnUnknownFileKeys().
+		Build()
+
+	data := `{"HTTP_PORT": 1, "database.user": "u", "database.pass": "p"}`
+	err := loader.LoadBytes(&cfg, []byte(data), "json")
+	if err == nil || !strings.Contains(err.Error(), `unknown keys "database.pass"`) {
+		t.Fatalf("must be an error, got %v", err)
+	}
+}
+
+func TestLiterals(t *testing.T) {
+	type LiteralConfig struct {
+		Host  string          `default:"localhost" json:"host"`
+		Port  int             `json:"port"`
+		Tags  []string        `json:"tags"`
+		Level string          `json:"level"`
+		Sub   struct{ N int } `json:"sub"`
+	}
+
+	f := func(override bool, want LiteralConfig, wantSource Source) {
+		t.Helper()
+
+		var cfg LiteralConfig
+		loader := LoaderFor(&cfg).
 			SkipFlags().
-			StopOnFileError().
-			WithFiles([]string{filepath}).
-			Build()
+			WithEnvPrefix("TST").
+			WithEnv(map[string]string{"TST_LEVEL": "env"}).
+			WithLiterals(map[string]string{
+				"Host":  "literal",
+				"Tags":  "a,b",
+				"Level": "literal",
+				"Sub.N": "7",
+			})
+		if override {
+			loader = loader.LiteralsOverride()
+		}
+		loader.Build()
 
-		var cfg TestConfig
-		if err := loader.Load(&cfg); err == nil {
+		if err := loader.LoadBytes(&cfg, []byte(`{"port": 80, "host": "file"}`), "json"); err != nil {
 			t.Fatal(err)
 		}
+		if !reflect.DeepEqual(want, cfg) {
+			t.Fatalf("want %+v, got %+v", want, cfg)
+		}
+		if got := loader.SourceOf("Level"); got != wantSource {
+			t.Fatalf("want %v, got %v", wantSource, got)
+		}
 	}
 
-	f("testdata/no_such_file.json")
-	f("testdata/bad_config.json")
-	f("testdata/unknown.ext")
+	want := LiteralConfig{Host: "file", Port: 80, Tags: []string{"a", "b"}, Level: "env"}
+	want.Sub.N = 7
+	f(false, want, SourceEnv)
+
+	want.Host, want.Level = "literal", "literal"
+	f(true, want, SourceLiteral)
 }
 
-func TestBadEnvs(t *testing.T) {
-	setEnv(t, "TST_HTTPPORT", "30a00")
-	defer os.Clearenv()
+func TestLiterals_Errors(t *testing.T) {
+	type LiteralConfig struct {
+		Port int
+		Sub  struct{ N int }
+	}
 
-	loader := LoaderFor(&TestConfig{}).
-		SkipDefaults().
+	f := func(literals map[string]string, wantErr string) {
+		t.Helper()
+
+		var cfg LiteralConfig
+		loader := LoaderFor(&cfg).
+			SkipFiles().
+			SkipEnvironment().
+			SkipFlags().
+			WithLiterals(literals).
+			Build()
+
+		err := loader.Load(&cfg)
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Fatalf("want error with %q, got %v", wantErr, err)
+		}
+	}
+
+	f(map[string]string{"Host": "a"}, `unknown field "Host" in literals`)
+	f(map[string]string{"Port": "abc"}, `incorrect literal of field "Port"`)
+
+	var cfg LiteralConfig
+	loader := LoaderFor(&cfg).
 		SkipFiles().
+		SkipEnvironment().
 		SkipFlags().
-		WithEnvPrefix("tst").
+		WithLiterals(map[string]string{"Port": "80", "Sub.N": "7"}).
 		Build()
 
-	var cfg TestConfig
-	if err := loader.Load(&cfg); err == nil {
+	if err := loader.LoadPrefix(&cfg, "Sub"); err != nil {
 		t.Fatal(err)
 	}
+	if cfg.Port != 0 || cfg.Sub.N != 7 {
+		t.Fatalf("got %+v", cfg)
+	}
 }
 
-func TestBadFlags(t *testing.T) {
-	type Config struct {
-		Field int
+func TestInlineValues(t *testing.T) {
+	type Server struct {
+		Host string `json:"host" yaml:"host"`
+		Port int    `json:"port" yaml:"port"`
+	}
+	type InlineConfig struct {
+		Main    Server            `json:"main" inline_json:"true"`
+		Backup  *Server           `json:"backup" inline_yaml:"true" default:"{host: backup, port: 2}"`
+		Servers []Server          `json:"servers" inline_json:"true"`
+		Labels  map[string]string `json:"labels" inline_yaml:"true"`
 	}
 
-	loader := LoaderFor(&Config{}).
-		SkipDefaults().
-		SkipFiles().
-		SkipEnvironment().
-		WithFlagPrefix("tst").
-		Build()
+	setEnv(t, "TST_SERVERS", `[{"host":"a","port":1},{"host":"b"}]`)
+	setEnv(t, "TST_LABELS", "team: core")
+	defer os.Clearenv()
 
-	// hack for test :(
-	if err := loader.Flags().Parse([]string{"-tst.field=10a01"}); err != nil {
-		t.Fatal(err)
+	f := func(data string) {
+		t.Helper()
+
+		var cfg InlineConfig
+		loader := LoaderFor(&cfg).
+			SkipFlags().
+			WithEnvPrefix("TST").
+			Build()
+
+		if err := loader.LoadBytes(&cfg, []byte(data), "json"); err != nil {
+			t.Fatal(err)
+		}
+
+		want := InlineConfig{
+			Main:    Server{Host: "main", Port: 80},
+			Backup:  &Server{Host: "backup", Port: 2},
+			Servers: []Server{{Host: "a", Port: 1}, {Host: "b"}},
+			Labels:  map[string]string{"team": "core"},
+		}
+		if !reflect.DeepEqual(want, cfg) {
+			t.Fatalf("want %+v, got %+v", want, cfg)
+		}
 	}
 
-	var cfg Config
-	if err := loader.Load(&cfg); err == nil {
-		t.Fatal(err)
+	f(`{"main": {"host": "main", "port": 80}}`)
+	f(`{"main": "{\"host\": \"main\", \"port\": 80}"}`)
+
+	var cfg InlineConfig
+	loader := LoaderFor(&cfg).SkipEnvironment().SkipFlags().Build()
+	err := loader.LoadBytes(&cfg, []byte(`{"main": "{\"host\": \"main\""}`), "json")
+	if err == nil || !strings.Contains(err.Error(), `incorrect inline json for field "Main"`) {
+		t.Fatalf("want inline error, got %v", err)
 	}
 }
 
-func TestCustomNames(t *testing.T) {
-	type Config struct {
-		A int `default:"-1" env:"one"`
-		B int `default:"-1" flag:"two"`
-		C int `default:"-1" env:"three" flag:"four"`
+func TestEnvKV(t *testing.T) {
+	type Endpoint struct {
+		Host    string
+		Port    int
+		Timeout time.Duration `env:"TIMEOUT_MS"`
+		Hosts   []string
+	}
+	type KVConfig struct {
+		Main    Endpoint  `env_kv:"true" default:"host=localhost,port=80"`
+		Backup  *Endpoint `env_kv:"true,ignore_unknown"`
+		Replica Endpoint  `env_kv:"true" merge:"true" default:"host=replica,port=81"`
 	}
 
-	loader := LoaderFor(&Config{}).Build()
-
-	setEnv(t, "ONE", "1")
-	setEnv(t, "three", "3")
+	setEnv(t, "TST_MAIN", "HOST=a, HOSTS=a,b, PORT=8080")
+	setEnv(t, "TST_BACKUP", "host=b,zone=eu,timeout_ms=2s")
+	setEnv(t, "TST_REPLICA", "PORT=8081")
 	defer os.Clearenv()
 
-	if err := loader.Flags().Parse([]string{"-two=2", "-four=4"}); err != nil {
-		t.Fatal(err)
-	}
+	var cfg KVConfig
+	loader := LoaderFor(&cfg).
+		SkipFiles().
+		SkipFlags().
+		WithEnvPrefix("TST").
+		Build()
 
-	var cfg Config
 	if err := loader.Load(&cfg); err != nil {
 		t.Fatal(err)
 	}
 
-	if want := 1; cfg.A != want {
-		t.Errorf("got %#v, want %#v", cfg.A, want)
+	want := KVConfig{
+		Main:    Endpoint{Host: "a", Port: 8080, Hosts: []string{"a", "b"}},
+		Backup:  &Endpoint{Host: "b", Timeout: 2 * time.Second},
+		Replica: Endpoint{Host: "replica", Port: 8081},
 	}
-	if want := 2; cfg.B != want {
-		t.Errorf("got %#v, want %#v", cfg.B, want)
+	if !reflect.DeepEqual(want, cfg) {
+		t.Fatalf("want %+v, got %+v", want, cfg)
 	}
-	if want := 4; cfg.C != want {
-		t.Errorf("got %#v, want %#v", cfg.C, want)
+
+	f := func(env, wantErr string) {
+		t.Helper()
+
+		setEnv(t, "TST_MAIN", env)
+		var cfg KVConfig
+		err := loader.Load(&cfg)
+		if err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Fatalf("want %q error, got %v", wantErr, err)
+		}
+	}
+
+	f("HOST=a,ZONE=eu", `unknown key "ZONE" of field "Main"`)
+	f("8080,HOST=a", `incorrect key=value item "8080" of field "Main"`)
+	f("HOST=a,b,PORT=http", `incorrect value of key "PORT"`)
+	f("PORT=http", `incorrect value of key "PORT"`)
+
+	type BadKVConfig struct {
+		Main struct {
+			Host string
+		} `env_kv:"true,bogus"`
+	}
+	var bad BadKVConfig
+	err := LoaderFor(&bad).SkipFiles().SkipFlags().Build().Load(&bad)
+	if want := `incorrect env_kv tag of field "Main": unknown option "bogus"`; err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("want %q error, got %v", want, err)
 	}
 }
 
-func TestWalkFields(t *testing.T) {
-	type Config struct {
-		A int `default:"-1" env:"one" marco:"polo"`
-		B struct {
-			C int `default:"-1" flag:"two" usage:"pretty simple usage duh"`
-			D struct {
-				E int `default:"-1" env:"three"`
-			}
-		}
+func TestDefaultsFile(t *testing.T) {
+	type Limits struct {
+		Conns int `yaml:"conns" json:"conns"`
+	}
+	type DefaultsConfig struct {
+		Host    string        `default:"tag-host" yaml:"host" json:"host"`
+		Port    int           `default:"80" yaml:"port" json:"port"`
+		Timeout time.Duration `default:"1s" yaml:"timeout" json:"timeout"`
+		Name    string        `yaml:"name" json:"name"`
+		Token   string        `yaml:"token" json:"token" source:"env"`
+		Limits  *Limits       `yaml:"limits" json:"limits"`
 	}
 
-	fields := []struct {
-		Name         string
-		ParentName   string
-		DefaultValue string
-		EnvName      string
-		FlagName     string
-		Usage        string
-	}{
-		{
-			Name:         "A",
-			EnvName:      "one",
-			DefaultValue: "-1",
-		},
-		{
-			Name:         "B.C",
-			ParentName:   "B",
-			FlagName:     "two",
-			DefaultValue: "-1",
-			Usage:        "pretty simple usage duh",
-		},
-		{
-			Name:         "B.D.E",
-			ParentName:   "B.D",
-			EnvName:      "three",
-			DefaultValue: "-1",
-		},
+	dir, err := ioutil.TempDir("", "aconfig")
+	if err != nil {
+		t.Fatal(err)
 	}
+	defer os.RemoveAll(dir)
 
-	i := 0
+	defaultsFile := filepath.Join(dir, "defaults.yaml")
+	writeFile(t, defaultsFile, "port: 8080\ntimeout: 5s\nname: def-name\ntoken: def-token\nlimits:\n  conns: 10\n")
+	mainFile := filepath.Join(dir, "config.json")
+	writeFile(t, mainFile, `{"name": "file-name"}`)
 
-	LoaderFor(&Config{}).Build().WalkFields(func(f Field) bool {
-		wantFields := fields[i]
-		if f.Name() != wantFields.Name {
-			t.Fatalf("got name %v, want %v", f.Name(), wantFields.Name)
+	setEnv(t, "TST_PORT", "9090")
+	defer os.Clearenv()
+
+	f := func(fallback bool) {
+		t.Helper()
+
+		var warnings []string
+		var cfg DefaultsConfig
+		loader := LoaderFor(&cfg).
+			SkipFlags().
+			WithEnvPrefix("TST").
+			WithDefaultsFile(defaultsFile).
+			WithFiles([]string{mainFile}).
+			WithLogger(func(format string, args ...interface{}) {
+				warnings = append(warnings, format)
+			})
+		if fallback {
+			loader = loader.DefaultsAsFallback()
 		}
+		loader.Build()
 
-		if parent, ok := f.Parent(); ok && parent.Name() != wantFields.ParentName {
-			t.Fatalf("got name %v, want %v", parent.Name(), wantFields.ParentName)
+		if err := loader.Load(&cfg); err != nil {
+			t.Fatal(err)
 		}
-		if f.DefaultValue() != wantFields.DefaultValue {
-			t.Fatalf("got default %#v, want %#v", f.DefaultValue(), wantFields.DefaultValue)
+
+		want := DefaultsConfig{
+			Host:    "tag-host",
+			Port:    9090,
+			Timeout: 5 * time.Second,
+			Name:    "file-name",
+			Limits:  &Limits{Conns: 10},
 		}
-		if f.Usage() != wantFields.Usage {
-			t.Fatalf("got usage %#v, want %#v", f.Usage(), wantFields.Usage)
+		if !reflect.DeepEqual(want, cfg) {
+			t.Fatalf("want %+v, got %+v", want, cfg)
 		}
-		i++
-		return true
-	})
-
-	if want := 3; i != want {
-		t.Fatalf("got %v, want %v", i, want)
-	}
-
-	i = 0
-	LoaderFor(&Config{}).Build().WalkFields(func(f Field) bool {
-		if i > 0 {
-			return false
+		if src := loader.SourceOf("Timeout"); src != SourceDefault {
+			t.Fatalf("want %v, got %v", SourceDefault, src)
 		}
-		if got := f.Tag("marco"); got != "polo" {
-			t.Fatalf("got %v, want %v", got, "polo")
+		if len(warnings) != 1 {
+			t.Fatalf("want a warning for Token, got %q", warnings)
 		}
-		i++
-		return true
-	})
-	if i != 1 {
-		t.Fatal()
 	}
-}
 
-func TestDontFillFlagsIfDisabled(t *testing.T) {
-	type Config struct {
-		A int `default:"1"`
-	}
+	f(false)
+	f(true)
 
-	loader := LoaderFor(&Config{}).
+	var cfg DefaultsConfig
+	loader := LoaderFor(&cfg).
+		SkipFiles().
+		SkipEnvironment().
 		SkipFlags().
+		WithDefaultsFile(filepath.Join(dir, "missing.yaml")).
 		Build()
-
-	if flags := loader.Flags().NFlag(); flags != 0 {
-		t.Errorf("want empty, got %v", flags)
+	err = loader.Load(&cfg)
+	if err == nil || !strings.Contains(err.Error(), "cannot read defaults file") {
+		t.Fatalf("want read error, got %v", err)
 	}
 }
 
-func TestPassNonStructs(t *testing.T) {
-	f := func(cfg interface{}) {
-		t.Helper()
-
-		defer func() {
-			t.Helper()
-			if err := recover(); err == nil {
-				t.Fatal()
-			}
-		}()
-
-		if err := LoaderFor(nil).Build().Load(cfg); err != nil {
-			t.Fatal(err)
-		}
+type PanicDefaultsConfig struct {
+	Port int
+	Sub  *struct {
+		Port int
 	}
+}
 
-	f(nil)
-	f(map[string]string{})
-	f([]string{})
-	f([4]string{})
-	f(func() {})
+func (c *PanicDefaultsConfig) SetDefaults() {
+	panic("no defaults")
 }
 
-func TestPanicWhenNotBuilt(t *testing.T) {
-	f := func(fn func()) {
-		t.Helper()
+func TestRecoverPanics(t *testing.T) {
+	var cfg TestConfig
+	err := LoaderFor(&cfg).SkipFiles().SkipFlags().Build().Load(cfg)
+	if err == nil || !strings.Contains(err.Error(), "Load requires a non-nil pointer") {
+		t.Fatalf("want pointer error, got %v", err)
+	}
 
+	// panic of the user code isn't hidden, lazy pointers are released anyway
+	var panicCfg PanicDefaultsConfig
+	func() {
 		defer func() {
-			t.Helper()
-			if err := recover(); err == nil {
-				t.Fatal()
+			if r := recover(); r != "no defaults" {
+				t.Fatalf("want %q panic, got %v", "no defaults", r)
 			}
 		}()
-		fn()
-	}
-
-	// ok to pass nils
-	f(func() {
-		_ = LoaderFor(nil).Load(nil)
-	})
-	f(func() {
-		_ = LoaderFor(nil).LoadWithFile(nil, "")
-	})
-	f(func() {
-		_ = LoaderFor(nil).Flags()
-	})
-	f(func() {
-		LoaderFor(nil).WalkFields(nil)
-	})
-}
-
-func loadFile(t *testing.T, file string, dst interface{}) {
-	f, err := os.Open(file)
-	if err != nil {
-		t.Fatal(err)
+		_ = LoaderFor(&panicCfg).SkipFiles().SkipFlags().Build().Load(&panicCfg)
+	}()
+	if panicCfg.Sub != nil {
+		t.Fatalf("want nil, got %+v", panicCfg.Sub)
 	}
-	ext := strings.ToLower(filepath.Ext(file))
-	switch ext {
-	case ".yaml", ".yml":
-		err = yaml.NewDecoder(f).Decode(dst)
-	case ".json":
-		err = json.NewDecoder(f).Decode(dst)
-	case ".toml":
-		_, err = toml.DecodeReader(f, dst)
-	}
-	if err != nil {
-		t.Fatal(err)
-	}
-}
 
-func setEnv(t *testing.T, key, value string) {
-	if err := os.Setenv(key, value); err != nil {
-		t.Fatal(err)
+	// the value isn't settable, so reflect panics
+	fd := &fieldData{name: "Sub.Port", value: reflect.ValueOf(0)}
+	err = setFieldDataHelper(fd, "80")
+	if err == nil || !strings.Contains(err.Error(), `cannot set field "Sub.Port": reflect`) {
+		t.Fatalf("want field error, got %v", err)
 	}
 }
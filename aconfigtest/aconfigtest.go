@@ -0,0 +1,121 @@
+// Package aconfigtest helps to test configuration structures loaded by aconfig.
+package aconfigtest
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cristalhq/aconfig"
+)
+
+// Check loads the configuration with the given environment instead of the process one
+// and validates it, on error the test fails with the error and the used environment.
+// The loader is changed to use env, nil env means no environment variables.
+// Check reports whether the configuration is loaded and valid.
+func Check(t testing.TB, loader *aconfig.Loader, into interface{}, env map[string]string) bool {
+	t.Helper()
+
+	if env == nil {
+		env = map[string]string{}
+	}
+	loader.WithEnv(env)
+
+	if err := loader.Load(into); err != nil {
+		t.Fatalf("aconfigtest: cannot load %T: %v%s", into, err, formatEnv(env))
+		return false
+	}
+	if err := loader.Validate(); err != nil {
+		t.Fatalf("aconfigtest: invalid %T: %v%s", into, err, formatEnv(env))
+		return false
+	}
+	return true
+}
+
+// Equal runs Check and compares the loaded configuration with want,
+// the test fails with a list of the differing fields.
+func Equal(t testing.TB, loader *aconfig.Loader, into, want interface{}, env map[string]string) {
+	t.Helper()
+
+	if !Check(t, loader, into, env) {
+		return
+	}
+
+	var lines []string
+	diff(reflect.ValueOf(want), reflect.ValueOf(into), "", &lines)
+	if len(lines) > 0 {
+		t.Fatalf("aconfigtest: unexpected %T:\n\t%s%s", into, strings.Join(lines, "\n\t"), formatEnv(env))
+	}
+}
+
+// diff adds lines like `Path: want X, got Y` for the differing exported fields.
+func diff(want, got reflect.Value, path string, lines *[]string) {
+	want, got = indirect(want), indirect(got)
+
+	if want.IsValid() && got.IsValid() && want.Type() == got.Type() && want.Kind() == reflect.Struct {
+		for i := 0; i < want.NumField(); i++ {
+			field := want.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := field.Name
+			if path != "" {
+				name = path + "." + name
+			}
+			diff(want.Field(i), got.Field(i), name, lines)
+		}
+		return
+	}
+
+	if !equal(want, got) {
+		if path == "" {
+			path = "config"
+		}
+		*lines = append(*lines, fmt.Sprintf("%s: want %s, got %s", path, format(want), format(got)))
+	}
+}
+
+func indirect(value reflect.Value) reflect.Value {
+	for value.IsValid() && (value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface) {
+		if value.IsNil() {
+			return reflect.Value{}
+		}
+		value = value.Elem()
+	}
+	return value
+}
+
+func equal(want, got reflect.Value) bool {
+	if !want.IsValid() || !got.IsValid() {
+		return want.IsValid() == got.IsValid()
+	}
+	return reflect.DeepEqual(want.Interface(), got.Interface())
+}
+
+func format(value reflect.Value) string {
+	if !value.IsValid() {
+		return "nil"
+	}
+	return fmt.Sprintf("%#v", value.Interface())
+}
+
+func formatEnv(env map[string]string) string {
+	if len(env) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString("\nenv:")
+	for _, name := range names {
+		fmt.Fprintf(&sb, "\n\t%s=%s", name, env[name])
+	}
+	return sb.String()
+}
@@ -0,0 +1,91 @@
+package aconfigtest
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cristalhq/aconfig"
+)
+
+type TestConfig struct {
+	Host  string `default:"localhost"`
+	Port  int    `default:"8080" max:"65535"`
+	Debug *bool
+	DB    struct {
+		Name string `required:"true"`
+	}
+}
+
+// fakeT records a failure instead of stopping the test.
+type fakeT struct {
+	testing.TB
+	msg string
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Fatalf(format string, args ...interface{}) {
+	t.msg = fmt.Sprintf(format, args...)
+}
+
+func newLoader() *aconfig.Loader {
+	return aconfig.LoaderFor(&TestConfig{}).
+		SkipFiles().
+		SkipFlags().
+		WithEnvPrefix("APP").
+		Build()
+}
+
+func TestCheck(t *testing.T) {
+	os.Clearenv()
+	if err := os.Setenv("APP_DB_NAME", "from-process"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Clearenv()
+
+	var cfg TestConfig
+	Check(t, newLoader(), &cfg, map[string]string{"APP_DB_NAME": "app", "APP_PORT": "9000"})
+
+	if cfg.DB.Name != "app" || cfg.Port != 9000 || cfg.Host != "localhost" {
+		t.Fatalf("got %+v", cfg)
+	}
+}
+
+func TestCheck_Fails(t *testing.T) {
+	f := func(env map[string]string, wantMsg string) {
+		t.Helper()
+
+		ft := &fakeT{TB: t}
+		if Check(ft, newLoader(), &TestConfig{}, env) {
+			t.Fatal("must fail")
+		}
+		if !strings.Contains(ft.msg, wantMsg) {
+			t.Fatalf("want message with %q, got %q", wantMsg, ft.msg)
+		}
+	}
+
+	f(nil, `field "DB.Name" is required`)
+	f(map[string]string{"APP_DB_NAME": "app", "APP_PORT": "abc"}, "env:\n\tAPP_DB_NAME=app\n\tAPP_PORT=abc")
+	f(map[string]string{"APP_DB_NAME": "app", "APP_PORT": "70000"}, "must be at most 65535")
+}
+
+func TestEqual(t *testing.T) {
+	yes := true
+	want := TestConfig{Host: "localhost", Port: 8080, Debug: &yes}
+	want.DB.Name = "app"
+
+	env := map[string]string{"APP_DB_NAME": "app", "APP_DEBUG": "true"}
+	Equal(t, newLoader(), &TestConfig{}, &want, env)
+
+	ft := &fakeT{TB: t}
+	want.Port = 80
+	want.DB.Name = "other"
+	Equal(ft, newLoader(), &TestConfig{}, &want, env)
+
+	wantMsg := "Port: want 80, got 8080\n\tDB.Name: want \"other\", got \"app\""
+	if !strings.Contains(ft.msg, wantMsg) {
+		t.Fatalf("want message with %q, got %q", wantMsg, ft.msg)
+	}
+}
@@ -0,0 +1,98 @@
+package aconfig
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// byteUnits are units of `bytesize:"true"` fields, both SI and IEC.
+var byteUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"pb":  1e15,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+	"pib": 1 << 50,
+}
+
+func isByteSize(field *fieldData) bool {
+	return field.field.Tag.Get(byteSizeTag) == "true"
+}
+
+// setByteSize sets an integer field from a size like `10MB` or `512KiB`, a value without unit is in bytes.
+func setByteSize(field *fieldData, value string) error {
+	// a plain number is set as is, float64 loses precision of the values above 2^53
+	if setPlainByteSize(field, strings.TrimSpace(value)) {
+		return nil
+	}
+
+	size, err := parseByteSize(value)
+	if err != nil {
+		return err
+	}
+
+	if field.value.Kind() >= reflect.Uint && field.value.Kind() <= reflect.Uintptr {
+		if size < 0 || size >= 1<<64 || field.value.OverflowUint(uint64(size)) {
+			return fmt.Errorf("cannot parse %q as %s: value out of range", value, field.value.Kind())
+		}
+		field.value.SetUint(uint64(size))
+		return nil
+	}
+
+	if size < math.MinInt64 || size >= 1<<63 || field.value.OverflowInt(int64(size)) {
+		return fmt.Errorf("cannot parse %q as %s: value out of range", value, field.value.Kind())
+	}
+	field.value.SetInt(int64(size))
+	return nil
+}
+
+// setPlainByteSize sets a number without unit and fraction, it returns false when the value isn't such a number
+// or doesn't fit the field, so it's reported by setByteSize.
+func setPlainByteSize(field *fieldData, value string) bool {
+	if field.value.Kind() >= reflect.Uint && field.value.Kind() <= reflect.Uintptr {
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || field.value.OverflowUint(n) {
+			return false
+		}
+		field.value.SetUint(n)
+		return true
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || field.value.OverflowInt(n) {
+		return false
+	}
+	field.value.SetInt(n)
+	return true
+}
+
+func parseByteSize(value string) (float64, error) {
+	s := strings.TrimSpace(value)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.' && r != '-' && r != '+'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+
+	num, unit := s[:i], strings.ToLower(strings.TrimSpace(s[i:]))
+	mult, ok := byteUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("cannot parse %q as byte size: unknown unit %q, want one of B, KB, MB, GB, TB, PB, KiB, MiB, GiB, TiB, PiB", value, s[i:])
+	}
+
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse %q as byte size, want a value like 10MB or 512KiB", value)
+	}
+	return math.Round(n * mult), nil
+}
@@ -0,0 +1,97 @@
+package aconfig
+
+import "reflect"
+
+// layoutKey identifies fields of a type, the format changes names with UseFileTags.
+type layoutKey struct {
+	typ    reflect.Type
+	format string
+}
+
+// fieldLayout is a result of walking a type, it's bound to a new value
+// instead of walking and parsing the tags once more.
+// Types with interface implementations aren't cached, 'cause their fields depend on the value.
+type fieldLayout struct {
+	fields     []*fieldData
+	structPtrs []*fieldData
+}
+
+func (l *Loader) cacheLayout(key layoutKey, fields []*fieldData) {
+	if len(l.impls) > 0 {
+		return
+	}
+	if l.layouts == nil {
+		l.layouts = map[layoutKey]*fieldLayout{}
+	}
+	l.layouts[key] = &fieldLayout{fields: fields, structPtrs: l.structPtrs}
+}
+
+// bindLayout returns copies of the cached fields bound to the value.
+// Pointers to structs are bound first (in walking order), so nil ones are allocated like in getFieldsHelper.
+func (l *Loader) bindLayout(layout *fieldLayout, value reflect.Value) []*fieldData {
+	copies := make(map[*fieldData]*fieldData, len(layout.fields))
+
+	var bind func(fd *fieldData) *fieldData
+	bind = func(fd *fieldData) *fieldData {
+		if fd == nil {
+			return nil
+		}
+		if c, ok := copies[fd]; ok {
+			return c
+		}
+
+		c := *fd
+		c.parent = bind(fd.parent)
+		c.embedded = bind(fd.embedded)
+		c.value = fieldByIndex(value, fd.index)
+		c.lazyValue = reflect.Value{}
+		c.touched = false
+		c.source = SourceUnset
+		c.usedAlias = ""
+		copies[fd] = &c
+		return &c
+	}
+
+	for _, fd := range layout.structPtrs {
+		c := bind(fd)
+		if c.value.IsNil() {
+			l.allocStructPtr(c)
+		}
+		l.structPtrs = append(l.structPtrs, c)
+	}
+
+	fields := make([]*fieldData, len(layout.fields))
+	for i, fd := range layout.fields {
+		fields[i] = bind(fd)
+	}
+	return fields
+}
+
+// fieldIndex returns index of the i-th field of the struct
+// which is the embedded field (if any) or the parent.
+func fieldIndex(parent, embedded *fieldData, i int) []int {
+	var base []int
+	switch {
+	case embedded != nil:
+		base = embedded.index
+	case parent != nil:
+		base = parent.index
+	}
+
+	index := make([]int, len(base), len(base)+1)
+	copy(index, base)
+	return append(index, i)
+}
+
+// fieldByIndex returns a nested field, pointers on the way must be allocated.
+func fieldByIndex(value reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 {
+			for value.Kind() == reflect.Ptr {
+				value = value.Elem()
+			}
+		}
+		value = value.Field(x)
+	}
+	return value
+}
@@ -0,0 +1,73 @@
+package aconfig
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// normalizeCase sets loaded values to their canonical form, see IgnoreCase:
+// string keys of maps are lower cased and values of `oneof` fields are spelled as in the tag.
+func (l *Loader) normalizeCase() error {
+	for _, fd := range l.fields {
+		if fd.isReleased() {
+			continue
+		}
+
+		value := fd.value
+		for value.Kind() == reflect.Ptr && !value.IsNil() {
+			value = value.Elem()
+		}
+
+		switch value.Kind() {
+		case reflect.Map:
+			if err := lowerMapKeys(fd, value); err != nil {
+				return err
+			}
+		case reflect.String:
+			if opt, ok := matchOneOf(fd.Tag(oneOfTag), value.String(), true); ok {
+				value.SetString(opt)
+			}
+		}
+	}
+	return nil
+}
+
+func lowerMapKeys(fd *fieldData, value reflect.Value) error {
+	if value.IsNil() || value.Type().Key().Kind() != reflect.String {
+		return nil
+	}
+
+	lowered := reflect.MakeMapWithSize(value.Type(), value.Len())
+	origKeys := map[string]string{}
+
+	iter := value.MapRange()
+	for iter.Next() {
+		key := iter.Key().String()
+		lower := strings.ToLower(key)
+		if orig, ok := origKeys[lower]; ok {
+			return fmt.Errorf("keys %q and %q of field %q differ only in case", orig, key, fd.name)
+		}
+		origKeys[lower] = key
+
+		newKey := reflect.New(value.Type().Key()).Elem()
+		newKey.SetString(lower)
+		lowered.SetMapIndex(newKey, iter.Value())
+	}
+	value.Set(lowered)
+	return nil
+}
+
+// matchOneOf returns an option from the `oneof` tag equal to the value.
+func matchOneOf(oneOf, value string, ignoreCase bool) (string, bool) {
+	if oneOf == "" {
+		return "", false
+	}
+	for _, opt := range strings.Split(oneOf, ",") {
+		opt = strings.TrimSpace(opt)
+		if opt == value || ignoreCase && strings.EqualFold(opt, value) {
+			return opt, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,50 @@
+package aconfig
+
+import (
+	"context"
+	"fmt"
+)
+
+// loadDefaultsFile sets the fields present in the defaults file, see WithDefaultsFile.
+// The file is decoded into a new value, so its fields get SourceDefault, not SourceFile.
+func (l *Loader) loadDefaultsFile(ctx context.Context) error {
+	file := l.config.DefaultsFile
+	data, ext, err := l.readFile(ctx, file)
+	if err != nil {
+		return fmt.Errorf("cannot read defaults file %q: %w", file, err)
+	}
+	if !isSupportedFormat(ext) {
+		return unsupportedFormatError(file, ext)
+	}
+
+	tmpFields, err := l.loadIntoTemp(func(tmp interface{}) error {
+		// `source` tags are checked for SourceDefault below,
+		// fields are copied, 'cause they can be in the layout cache
+		fields := make([]*fieldData, len(l.fields))
+		for i, fd := range l.fields {
+			c := *fd
+			c.sources = SourceUnset
+			fields[i] = &c
+		}
+		l.fields = fields
+		return l.decodeFile(data, ext, tmp)
+	})
+	if err != nil {
+		return fmt.Errorf("defaults file parsing error: %w", err)
+	}
+
+	for _, fd := range l.fields {
+		tmp, ok := tmpFields[fd.name]
+		if !ok || tmp.source != SourceFile {
+			continue
+		}
+		if !fd.allows(SourceDefault) {
+			l.warnIgnored(fd, SourceDefault, fmt.Sprintf("file %q", file))
+			continue
+		}
+		fd.value.Set(tmp.value)
+		l.setSource(fd, SourceDefault, valueString(tmp.value))
+		l.touch(fd)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package aconfig
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// configFiles returns the files from WithFiles followed by the files found by WithFileDiscovery.
+func (l *Loader) configFiles() []string {
+	if l.config.FileBaseName == "" {
+		return l.config.Files
+	}
+
+	files := make([]string, 0, len(l.config.Files))
+	files = append(files, l.config.Files...)
+	return append(files, l.discoverFiles()...)
+}
+
+// discoverFiles returns existing files like `<dir>/<base>.json` in order of the dirs and supportedFormats.
+func (l *Loader) discoverFiles() []string {
+	dirs := l.config.FileDirs
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+
+	var files []string
+	for _, dir := range dirs {
+		for _, ext := range supportedFormats {
+			file := filepath.Join(dir, l.config.FileBaseName+ext)
+			if info, err := os.Stat(file); err == nil && !info.IsDir() {
+				files = append(files, file)
+			}
+		}
+	}
+	return files
+}
@@ -0,0 +1,105 @@
+package aconfig
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// parseEnvCollect returns a prefix from `env_prefix_collect:"FEATURE_"` tag,
+// keys are lower cased unless `keep` option is set: `env_prefix_collect:"FEATURE_,keep"`.
+func parseEnvCollect(tag string) (prefix string, keepCase bool, err error) {
+	items := splitTagList(tag)
+	if len(items) == 0 {
+		return "", false, errors.New("prefix is empty")
+	}
+
+	for _, opt := range items[1:] {
+		switch opt {
+		case "lower":
+			keepCase = false
+		case "keep":
+			keepCase = true
+		default:
+			return "", false, fmt.Errorf("unknown option %q, want lower or keep", opt)
+		}
+	}
+	return strings.ToUpper(items[0]), keepCase, nil
+}
+
+// envCollectPrefixes returns prefixes of the env names collected into the field, the env prefixes are added.
+func (l *Loader) envCollectPrefixes(field *fieldData) []string {
+	prefix, _, err := parseEnvCollect(field.Tag(envCollectTag))
+	if err != nil {
+		return nil
+	}
+
+	prefixes := l.envPrefixes()
+	names := make([]string, 0, len(prefixes))
+	for _, p := range prefixes {
+		names = append(names, strings.ToUpper(p)+prefix)
+	}
+	return names
+}
+
+// loadEnvCollect sets the map field from all env variables with the prefix of `env_prefix_collect` tag,
+// names without the prefix are the keys. Variables with the main env prefix win over the fallback ones.
+// For a field with `merge:"true"` tag the entries are added to the map.
+func (l *Loader) loadEnvCollect(field *fieldData) error {
+	_, keepCase, err := parseEnvCollect(field.Tag(envCollectTag))
+	if err != nil {
+		return fmt.Errorf("incorrect %s tag of field %q: %w", envCollectTag, field.name, err)
+	}
+	if field.value.Kind() != reflect.Map || field.value.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("%s tag of field %q requires a map with string keys", envCollectTag, field.name)
+	}
+
+	env := l.environ()
+	values := map[string]string{}
+	prefixes := l.envCollectPrefixes(field)
+	for i := len(prefixes) - 1; i >= 0; i-- {
+		for _, e := range env {
+			kv := strings.SplitN(e, "=", 2)
+			if len(kv) != 2 || !strings.HasPrefix(kv[0], prefixes[i]) {
+				continue
+			}
+			if !l.config.EmptyEnvClears && kv[1] == "" {
+				continue
+			}
+
+			key := strings.TrimPrefix(kv[0], prefixes[i])
+			if key == "" {
+				continue
+			}
+			if !keepCase {
+				key = strings.ToLower(key)
+			}
+			values[key] = kv[1]
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if field.value.IsNil() || !field.merge {
+		field.value.Set(reflect.MakeMapWithSize(field.value.Type(), len(values)))
+	}
+	entries := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if err := setMapEntry(field, key, values[key]); err != nil {
+			return fmt.Errorf("incorrect value %q of key %q for field %q: %w", values[key], key, field.name, err)
+		}
+		entries = append(entries, key+":"+values[key])
+	}
+	l.setSource(field, SourceEnv, strings.Join(entries, ","))
+	l.touch(field)
+	return nil
+}
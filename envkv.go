@@ -0,0 +1,134 @@
+package aconfig
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// parseEnvKV returns whether the field is set from `key=value` pairs, see setStructFromKV,
+// unknown keys are ignored with `ignore_unknown` option: `env_kv:"true,ignore_unknown"`.
+func parseEnvKV(tag string) (ok, ignoreUnknown bool, err error) {
+	items := splitTagList(tag)
+	if len(items) == 0 || items[0] != "true" {
+		return false, false, nil
+	}
+
+	for _, opt := range items[1:] {
+		switch opt {
+		case "ignore_unknown":
+			ignoreUnknown = true
+		default:
+			return false, false, fmt.Errorf("unknown option %q of %s tag, want ignore_unknown", opt, envKVTag)
+		}
+	}
+	return true, ignoreUnknown, nil
+}
+
+// isEnvKV reports whether the field has `env_kv:"true"` tag, options are checked by checkEnvKVTags,
+// so a field with a malformed tag is still set as a single value and its error isn't lost.
+func isEnvKV(field reflect.StructField) bool {
+	items := splitTagList(field.Tag.Get(envKVTag))
+	return len(items) > 0 && items[0] == "true"
+}
+
+// checkEnvKVTags returns an error for a malformed `env_kv` tag, see parseEnvKV.
+func (l *Loader) checkEnvKVTags() error {
+	for _, fd := range l.fields {
+		if !isEnvKV(fd.field) {
+			continue
+		}
+		if _, _, err := parseEnvKV(fd.Tag(envKVTag)); err != nil {
+			return fmt.Errorf("incorrect %s tag of field %q: %w", envKVTag, fd.name, err)
+		}
+	}
+	return nil
+}
+
+// setStructFromKV sets a struct field from comma separated pairs like `HOST=a,PORT=8080`,
+// keys are names (or `env` tags) of the struct fields in any case.
+// A comma not followed by a key like `PORT=` is a part of the value: `HOSTS=a,b,PORT=8080`.
+// The struct is replaced, for a field with `merge:"true"` tag only the given fields are set.
+func setStructFromKV(field *fieldData, value string) error {
+	_, ignoreUnknown, err := parseEnvKV(field.Tag(envKVTag))
+	if err != nil {
+		return err
+	}
+
+	typ := field.value.Type()
+	val := reflect.New(typ).Elem()
+	if field.merge {
+		val.Set(field.value)
+	}
+
+	for _, item := range splitKVItems(value) {
+		pair := strings.SplitN(item, "=", 2)
+		if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" {
+			return fmt.Errorf("incorrect key=value item %q of field %q", item, field.name)
+		}
+		key, v := strings.TrimSpace(pair[0]), strings.TrimSpace(pair[1])
+
+		i, ok := kvFieldIndex(typ, key)
+		if !ok {
+			if ignoreUnknown {
+				continue
+			}
+			return fmt.Errorf("unknown key %q of field %q", key, field.name)
+		}
+
+		sub := &fieldData{
+			name:  field.name + "." + typ.Field(i).Name,
+			field: typ.Field(i),
+			value: val.Field(i),
+		}
+		if err := setFieldDataHelper(sub, v); err != nil {
+			return fmt.Errorf("incorrect value of key %q: %w", key, err)
+		}
+	}
+	field.value.Set(val)
+	return nil
+}
+
+// splitKVItems splits the value by the commas followed by a key and `=`.
+func splitKVItems(value string) []string {
+	parts := strings.Split(value, ",")
+	items := parts[:1]
+	for _, part := range parts[1:] {
+		if isKVItem(part) {
+			items = append(items, part)
+			continue
+		}
+		items[len(items)-1] += "," + part
+	}
+	return items
+}
+
+// isKVItem reports whether the part starts with a key like `PORT=`.
+func isKVItem(part string) bool {
+	key := strings.TrimSpace(part)
+	i := strings.IndexByte(key, '=')
+	if i <= 0 {
+		return false
+	}
+	for _, r := range key[:i] {
+		if r != '_' && r != '-' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// kvFieldIndex returns an index of the exported struct field with the given name or `env` tag.
+func kvFieldIndex(typ reflect.Type, key string) (int, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		if strings.EqualFold(sf.Name, key) || strings.EqualFold(sf.Tag.Get(envNameTag), key) {
+			return i, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,236 @@
+package aconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// GenerateYAML returns a YAML document with default values of the configuration,
+// keys are named like the YAML decoder expects, so the document can be loaded back.
+// Fields with empty or zero defaults are omitted unless IncludeZeroDefaults is set,
+// fields with `omitempty` option in `yaml` tag are omitted anyway.
+// Template defaults are omitted too, they're resolved from other fields on load.
+func (l *Loader) GenerateYAML() ([]byte, error) {
+	l.assertBuilt()
+
+	doc, err := l.generateDoc("yaml", generatePath)
+	if err != nil {
+		return nil, fmt.Errorf("aconfig: cannot generate config: %w", err)
+	}
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("aconfig: cannot generate config: %w", err)
+	}
+	return data, nil
+}
+
+// GenerateJSON returns a JSON document with default values of the configuration like GenerateYAML,
+// keys and `omitempty` options are taken from `json` tags, durations are written as strings like "5s".
+func (l *Loader) GenerateJSON() ([]byte, error) {
+	l.assertBuilt()
+
+	doc, err := l.generateDoc("json", generateJSONPath)
+	if err != nil {
+		return nil, fmt.Errorf("aconfig: cannot generate config: %w", err)
+	}
+	data, err := json.MarshalIndent(jsonObject(doc), "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("aconfig: cannot generate config: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// generateDoc returns defaults of the fields by their paths in the document of the format.
+func (l *Loader) generateDoc(format string, pathOf func(fd *fieldData) ([]string, bool)) (yaml.MapSlice, error) {
+	var doc yaml.MapSlice
+	for _, fd := range l.fields {
+		path, ok := pathOf(fd)
+		if !ok || isTemplate(fd.defaultValue) {
+			continue
+		}
+
+		value, err := defaultOf(fd)
+		if err != nil {
+			return nil, err
+		}
+		if isZeroDefault(value) && (!l.config.IncludeZeroDefaults || hasTagOption(fd.field, format, "omitempty")) {
+			continue
+		}
+		if format == "json" {
+			value = durationString(value)
+		}
+		doc = setMapSlice(doc, path, value)
+	}
+	return doc, nil
+}
+
+// defaultOf returns the default value of the field parsed into the field type.
+func defaultOf(fd *fieldData) (interface{}, error) {
+	value := reflect.New(fd.field.Type).Elem()
+	tmp := &fieldData{name: fd.name, field: fd.field, value: value}
+	if err := setDefaultValue(tmp, fd.defaultValue); err != nil {
+		return nil, fmt.Errorf("incorrect default of field %q: %w", fd.name, err)
+	}
+	return value.Interface(), nil
+}
+
+func isZeroDefault(value interface{}) bool {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v.IsZero()
+}
+
+// generatePath returns keys of the field in the generated document,
+// false is returned for a field skipped with `yaml:"-"`.
+func generatePath(fd *fieldData) ([]string, bool) {
+	var path []string
+	for f := fd; f != nil; f = f.parent {
+		name, ok := yamlKey(f.field)
+		if !ok {
+			return nil, false
+		}
+		path = append([]string{name}, path...)
+
+		// embedded structs are nested in YAML unless they're inlined
+		for e := f.embedded; e != nil; e = e.embedded {
+			if hasTagOption(e.field, "yaml", "inline") {
+				continue
+			}
+			name, ok := yamlKey(e.field)
+			if !ok {
+				return nil, false
+			}
+			path = append([]string{name}, path...)
+		}
+	}
+	return path, true
+}
+
+// yamlKey returns a key of the field like the YAML decoder does.
+func yamlKey(field reflect.StructField) (string, bool) {
+	switch name := fileTagName(field, ".yaml"); name {
+	case "-":
+		return "", false
+	case "":
+		return strings.ToLower(field.Name), true
+	default:
+		return name, true
+	}
+}
+
+// generateJSONPath returns keys of the field in the generated JSON document,
+// false is returned for a field skipped with `json:"-"`.
+func generateJSONPath(fd *fieldData) ([]string, bool) {
+	var path []string
+	for f := fd; f != nil; f = f.parent {
+		name, ok := jsonKey(f.field)
+		if !ok {
+			return nil, false
+		}
+		path = append([]string{name}, path...)
+
+		// embedded structs are inlined in JSON unless they're named in the tag
+		for e := f.embedded; e != nil; e = e.embedded {
+			name := fileTagName(e.field, ".json")
+			if name == "-" {
+				return nil, false
+			}
+			if name != "" {
+				path = append([]string{name}, path...)
+			}
+		}
+	}
+	return path, true
+}
+
+// jsonKey returns a key of the field like the JSON encoder does.
+func jsonKey(field reflect.StructField) (string, bool) {
+	switch name := fileTagName(field, ".json"); name {
+	case "-":
+		return "", false
+	case "":
+		return field.Name, true
+	default:
+		return name, true
+	}
+}
+
+func hasTagOption(field reflect.StructField, tag, option string) bool {
+	opts := strings.Split(field.Tag.Get(tag), ",")
+	for _, opt := range opts[1:] {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+func setMapSlice(ms yaml.MapSlice, path []string, value interface{}) yaml.MapSlice {
+	key := path[0]
+	for i := range ms {
+		if ms[i].Key != key {
+			continue
+		}
+		if len(path) > 1 {
+			sub, _ := ms[i].Value.(yaml.MapSlice)
+			ms[i].Value = setMapSlice(sub, path[1:], value)
+		}
+		return ms
+	}
+
+	if len(path) > 1 {
+		value = setMapSlice(nil, path[1:], value)
+	}
+	return append(ms, yaml.MapItem{Key: key, Value: value})
+}
+
+// durationString returns a duration as a string like "5s", the JSON encoder writes nanoseconds.
+func durationString(value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	return value
+}
+
+// jsonObject is encoded as a JSON object with the keys in the order of the items.
+type jsonObject yaml.MapSlice
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, item := range o {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(item.Key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+
+		value := item.Value
+		if sub, ok := value.(yaml.MapSlice); ok {
+			value = jsonObject(sub)
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,127 @@
+package aconfig
+
+import (
+	"math"
+	"reflect"
+	"strings"
+)
+
+// GetString returns a string by a dotted path like `HTTP.Host` or `Extra.plugin.name`,
+// where `Extra` is a map or a field with `aconfig:",remain"` tag.
+// False is returned for a missing path or a value of another type.
+func (l *Loader) GetString(path string) (string, bool) {
+	value, ok := l.lookup(path)
+	if !ok || value.Kind() != reflect.String {
+		return "", false
+	}
+	return value.String(), true
+}
+
+// GetInt returns an integer by a dotted path, see GetString.
+// Floats without a fractional part are accepted, JSON numbers are decoded as floats.
+func (l *Loader) GetInt(path string) (int64, bool) {
+	value, ok := l.lookup(path)
+	if !ok {
+		return 0, false
+	}
+
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if value.Uint() > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(value.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		f := value.Float()
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= 1<<63 {
+			return 0, false
+		}
+		return int64(f), true
+	default:
+		return 0, false
+	}
+}
+
+// GetBool returns a bool by a dotted path, see GetString.
+func (l *Loader) GetBool(path string) (bool, bool) {
+	value, ok := l.lookup(path)
+	if !ok || value.Kind() != reflect.Bool {
+		return false, false
+	}
+	return value.Bool(), true
+}
+
+// lookup finds a loaded value by the field name or walks the config for maps.
+func (l *Loader) lookup(path string) (reflect.Value, bool) {
+	for _, fd := range l.fields {
+		if fd.name == path && !fd.isReleased() {
+			return indirectValue(fd.value)
+		}
+	}
+	if l.dst == nil {
+		return reflect.Value{}, false
+	}
+	return lookupValue(reflect.ValueOf(l.dst), strings.Split(path, "."))
+}
+
+func lookupValue(value reflect.Value, path []string) (reflect.Value, bool) {
+	for _, key := range path {
+		var ok bool
+		if value, ok = indirectValue(value); !ok {
+			return reflect.Value{}, false
+		}
+
+		switch value.Kind() {
+		case reflect.Struct:
+			field := value.FieldByName(key)
+			if !field.IsValid() {
+				field = remainValue(value)
+				if field, ok = mapIndex(field, key); !ok {
+					return reflect.Value{}, false
+				}
+			}
+			value = field
+
+		case reflect.Map:
+			if value, ok = mapIndex(value, key); !ok {
+				return reflect.Value{}, false
+			}
+
+		default:
+			return reflect.Value{}, false
+		}
+	}
+	return indirectValue(value)
+}
+
+// remainValue returns a field with `aconfig:",remain"` tag.
+func remainValue(value reflect.Value) reflect.Value {
+	typ := value.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		if isRemainField(typ.Field(i)) {
+			return value.Field(i)
+		}
+	}
+	return reflect.Value{}
+}
+
+func mapIndex(value reflect.Value, key string) (reflect.Value, bool) {
+	if !value.IsValid() || value.Kind() != reflect.Map || value.Type().Key().Kind() != reflect.String {
+		return reflect.Value{}, false
+	}
+	elem := value.MapIndex(reflect.ValueOf(key).Convert(value.Type().Key()))
+	return elem, elem.IsValid()
+}
+
+// indirectValue unwraps pointers and interfaces, false is returned for nil.
+func indirectValue(value reflect.Value) (reflect.Value, bool) {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return reflect.Value{}, false
+		}
+		value = value.Elem()
+	}
+	return value, value.IsValid()
+}
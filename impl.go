@@ -0,0 +1,174 @@
+package aconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// maxImplDepth limits nesting of interface fields with implementations.
+const maxImplDepth = 10
+
+// RegisterImpl registers a constructor of an interface implementation.
+// An interface field with `impl:"Type"` tag gets the implementation named by its `Type` sibling field,
+// the constructor must return a pointer to a struct, which is loaded as an ordinary struct field:
+//
+//	type StorageConfig struct {
+//		Type    string
+//		Backend StorageBackend `impl:"Type"`
+//	}
+//
+//	loader.RegisterImpl("s3", func() interface{} { return &S3Config{} })
+func (l *Loader) RegisterImpl(name string, newImpl func() interface{}) *Loader {
+	if l.config.Impls == nil {
+		l.config.Impls = map[string]func() interface{}{}
+	}
+	l.config.Impls[name] = newImpl
+	return l
+}
+
+// isImplField reports whether the field is an interface with an implementation selector.
+func isImplField(field reflect.StructField) bool {
+	return field.Type.Kind() == reflect.Interface && field.Tag.Get(implTag) != ""
+}
+
+// loadWithImpls loads sources, instantiates implementations selected by the loaded values
+// and loads sources once more to populate them.
+func (l *Loader) loadWithImpls(ctx context.Context, into interface{}) error {
+	if len(l.impls) == 0 {
+		return l.loadSources(ctx, into)
+	}
+
+	// lazy pointers are released, so they're allocated again for each pass
+	for _, fd := range l.lazy {
+		fd.value.Set(reflect.Zero(fd.value.Type()))
+	}
+	dst := reflect.ValueOf(into)
+	for dst.Kind() == reflect.Ptr {
+		dst = dst.Elem()
+	}
+	snapshot := reflect.New(dst.Type()).Elem()
+	snapshot.Set(dst)
+
+	selected := map[string]string{}
+	for i := 0; i <= maxImplDepth; i++ {
+		l.fileErrors = nil
+		if err := l.loadSources(ctx, into); err != nil {
+			return err
+		}
+
+		changed, err := l.selectImpls(selected)
+		if err != nil || !changed {
+			return err
+		}
+
+		dst.Set(snapshot)
+		if err := l.instantiateImpls(into, selected); err != nil {
+			return err
+		}
+	}
+	return errors.New("too deep nesting of interface implementations")
+}
+
+// selectImpls adds to selected implementations of the interface fields which aren't instantiated yet.
+func (l *Loader) selectImpls(selected map[string]string) (bool, error) {
+	changed := false
+	for _, fd := range l.impls {
+		if !fd.value.IsNil() {
+			continue
+		}
+		if !fd.implSelector.IsValid() {
+			return false, fmt.Errorf("unknown field %q in %s tag of field %q", fd.field.Tag.Get(implTag), implTag, fd.name)
+		}
+		if name := valueString(fd.implSelector); name != "" {
+			selected[fd.name] = name
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// instantiateImpls sets selected implementations, nested interface fields appear after walking the parent ones.
+func (l *Loader) instantiateImpls(into interface{}, selected map[string]string) error {
+	for {
+		l.fields = l.scopeFields(l.getFields(into))
+
+		assigned := false
+		for _, fd := range l.impls {
+			name, ok := selected[fd.name]
+			if !ok || !fd.value.IsNil() {
+				continue
+			}
+			impl, err := l.newImpl(fd, name)
+			if err != nil {
+				return err
+			}
+			fd.value.Set(impl)
+			assigned = true
+		}
+		if !assigned {
+			return nil
+		}
+	}
+}
+
+func (l *Loader) newImpl(fd *fieldData, name string) (reflect.Value, error) {
+	newImpl, ok := l.config.Impls[name]
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("unknown implementation %q of field %q", name, fd.name)
+	}
+
+	impl := reflect.ValueOf(newImpl())
+	switch {
+	case !impl.IsValid() || impl.Kind() != reflect.Ptr || impl.Elem().Kind() != reflect.Struct:
+		return reflect.Value{}, fmt.Errorf("implementation %q of field %q must be a pointer to a struct", name, fd.name)
+	case !impl.Type().Implements(fd.value.Type()):
+		return reflect.Value{}, fmt.Errorf("implementation %q of field %q doesn't implement %s", name, fd.name, fd.value.Type())
+	}
+	return impl, nil
+}
+
+// implValue is a part of the file for an interface field.
+type implValue struct {
+	container map[string]interface{}
+	key       string
+	value     interface{}
+	orig      interface{} // value with original keys and numbers, see decodeRawData
+}
+
+// takeImplValues removes from raw values of interface fields, decoders cannot decode them.
+func (l *Loader) takeImplValues(raw map[string]interface{}, ext string) map[*fieldData]implValue {
+	values := map[*fieldData]implValue{}
+	for _, fd := range l.impls {
+		container, key, ok := lookupFileKey(raw, fd, ext)
+		if !ok {
+			continue
+		}
+		values[fd] = implValue{container: container, key: key, value: container[key]}
+		delete(container, key)
+	}
+	return values
+}
+
+// decodeImplValues decodes the file parts into the implementations and puts them back to raw.
+func (l *Loader) decodeImplValues(values map[*fieldData]implValue, ext string) error {
+	for fd, v := range values {
+		v.container[v.key] = v.value
+		if fd.value.IsNil() || v.value == nil {
+			continue
+		}
+
+		if _, ok := v.value.(map[string]interface{}); !ok {
+			return fmt.Errorf("incorrect value of field %q: want an object", fd.name)
+		}
+		data, err := encodeData(v.orig, ext)
+		if err != nil {
+			return err
+		}
+		if err := decodeData(data, ext, fd.value.Interface()); err != nil {
+			return fmt.Errorf("incorrect value of field %q: %w", fd.name, err)
+		}
+	}
+	return nil
+}
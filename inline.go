@@ -0,0 +1,44 @@
+package aconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"gopkg.in/yaml.v2"
+)
+
+// inlineFormat returns a format of the field values set from a string,
+// see `inline_json:"true"` and `inline_yaml:"true"` tags.
+func inlineFormat(field reflect.StructField) string {
+	switch {
+	case field.Tag.Get(inlineJSONTag) == "true":
+		return "json"
+	case field.Tag.Get(inlineYAMLTag) == "true":
+		return "yaml"
+	default:
+		return ""
+	}
+}
+
+func isInlineKind(kind reflect.Kind) bool {
+	return kind == reflect.Struct || kind == reflect.Slice || kind == reflect.Map
+}
+
+// setInline sets a struct, slice or map field from a JSON or YAML document in a string,
+// the previous value is replaced, not merged.
+func setInline(field *fieldData, format, value string) error {
+	val := reflect.New(field.value.Type())
+
+	var err error
+	if format == "json" {
+		err = json.Unmarshal([]byte(value), val.Interface())
+	} else {
+		err = yaml.Unmarshal([]byte(value), val.Interface())
+	}
+	if err != nil {
+		return fmt.Errorf("incorrect inline %s for field %q: %w", format, field.name, err)
+	}
+	field.value.Set(val.Elem())
+	return nil
+}
@@ -0,0 +1,69 @@
+package aconfig
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// KVSource is a key-value store like Consul or etcd.
+type KVSource interface {
+	// Get returns value of the key, ok is false for a missing key.
+	// Context is the one passed to LoadContext, a lookup should stop when it's done.
+	Get(ctx context.Context, key string) (value string, ok bool, err error)
+}
+
+// MapKVSource is a KVSource backed by a map, useful for tests.
+type MapKVSource map[string]string
+
+// Get implements KVSource.
+func (m MapKVSource) Get(ctx context.Context, key string) (string, bool, error) {
+	value, ok := m[key]
+	return value, ok, nil
+}
+
+// WithKVSource sets a key-value store, values with keys like `prefix/HTTP/Port` are loaded
+// after files and before environment. Key for a field can be set with `kv` tag.
+func (l *Loader) WithKVSource(src KVSource, prefix string) *Loader {
+	l.config.KVSource = src
+	l.config.KVPrefix = prefix
+	return l
+}
+
+func (l *Loader) loadKV(ctx context.Context) error {
+	for _, field := range l.fields {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		key := l.getKVName(field)
+		v, ok, err := l.config.KVSource.Get(ctx, key)
+		if err != nil {
+			return fmt.Errorf("cannot get key %q from kv: %w", key, err)
+		}
+		if !ok {
+			continue
+		}
+		if !field.allows(SourceKV) {
+			l.warnIgnored(field, SourceKV, fmt.Sprintf("key %q", key))
+			continue
+		}
+		if err := l.setFieldData(field, v); err != nil {
+			return fmt.Errorf("incorrect value of key %q for field %q: %w", key, field.name, err)
+		}
+		l.setSource(field, SourceKV, v)
+		l.touch(field)
+	}
+	return nil
+}
+
+func (l *Loader) getKVName(field *fieldData) string {
+	name := strings.ReplaceAll(field.name, ".", "/")
+	if field.kvName != "" {
+		name = field.kvName
+	}
+	if l.config.KVPrefix == "" {
+		return name
+	}
+	return strings.TrimSuffix(l.config.KVPrefix, "/") + "/" + name
+}
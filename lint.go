@@ -0,0 +1,131 @@
+package aconfig
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Lint returns errors for the fields which cannot be set by any source:
+// fields of unsupported types (like funcs and channels), structs without exported fields,
+// unexported embedded structs and fields with `source` tag of the skipped sources only.
+// Lint doesn't load the configuration, it's meant to be called in tests.
+func (l *Loader) Lint() []error {
+	l.assertBuilt()
+
+	var errs []error
+	typ := reflect.TypeOf(l.src)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ != nil && typ.Kind() == reflect.Struct {
+		lintStruct(typ, "", map[reflect.Type]bool{}, &errs)
+	}
+
+	enabled := l.enabledSources()
+	for _, fd := range l.fields {
+		if fd.sources != SourceUnset && fd.sources&enabled == 0 {
+			errs = append(errs, fmt.Errorf("field %q can be set only from %s, but the loader skips it", fd.name, fd.sources))
+		}
+	}
+	return errs
+}
+
+// enabledSources returns the sources which aren't skipped by the loader.
+func (l *Loader) enabledSources() Source {
+	s := l.config.sources()
+	if l.config.KVSource == nil {
+		s &^= SourceKV
+	}
+	if l.config.Literals != nil {
+		s |= SourceLiteral
+	}
+	return s
+}
+
+// lintStruct adds errors for the fields of the struct type like getFieldsHelper skips them,
+// it returns the number of the fields which can be set.
+func lintStruct(typ reflect.Type, path string, visiting map[reflect.Type]bool, errs *[]error) int {
+	if visiting[typ] {
+		return 1
+	}
+	visiting[typ] = true
+	defer delete(visiting, typ)
+
+	settable := 0
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := makePath(path, field.Name)
+		// fields of embedded structs are flattened
+		childPath := name
+		if field.Anonymous {
+			childPath = path
+		}
+
+		if field.PkgPath != "" {
+			if field.Anonymous && hasExportedFields(field.Type) {
+				*errs = append(*errs, fmt.Errorf("fields of unexported embedded %s are skipped, export it", field.Type))
+			}
+			continue
+		}
+		if isRemainField(field) || isImplField(field) {
+			settable++
+			continue
+		}
+		if isUnsupportedType(field.Type) || isUnsupportedElem(field.Type) {
+			*errs = append(*errs, fmt.Errorf("field %q of type %s cannot be set by any source", name, field.Type))
+			continue
+		}
+
+		elemField := field
+		for elemField.Type.Kind() == reflect.Ptr {
+			elemField.Type = elemField.Type.Elem()
+		}
+		if elemField.Type.Kind() != reflect.Struct || isLeafStruct(elemField) {
+			settable++
+			continue
+		}
+		if n := lintStruct(elemField.Type, childPath, visiting, errs); n > 0 {
+			settable += n
+		} else if !field.Anonymous {
+			*errs = append(*errs, fmt.Errorf("field %q of type %s has no fields to set", name, field.Type))
+		}
+	}
+	return settable
+}
+
+func makePath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// isUnsupportedElem reports whether elements (or keys) of a slice, an array or a map cannot be set.
+func isUnsupportedElem(typ reflect.Type) bool {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.Slice, reflect.Array:
+		return isUnsupportedType(typ.Elem()) || isUnsupportedElem(typ.Elem())
+	case reflect.Map:
+		return isUnsupportedType(typ.Key()) || isUnsupportedType(typ.Elem()) || isUnsupportedElem(typ.Elem())
+	default:
+		return false
+	}
+}
+
+func hasExportedFields(typ reflect.Type) bool {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		if typ.Field(i).PkgPath == "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package aconfig
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// loadLiterals sets the fields from WithLiterals by their names like `HTTP.Port`.
+// In LoadPrefix the values of other fields are skipped.
+func (l *Loader) loadLiterals() error {
+	fields := make(map[string]*fieldData, len(l.fields))
+	for _, fd := range l.fields {
+		fields[fd.name] = fd
+	}
+
+	names := make([]string, 0, len(l.config.Literals))
+	for name := range l.config.Literals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := l.config.Literals[name]
+		fd, ok := fields[name]
+		switch {
+		case !ok && l.prefix != "" && name != l.prefix && !strings.HasPrefix(name, l.prefix+"."):
+			continue
+		case !ok:
+			return fmt.Errorf("unknown field %q in literals", name)
+		case !fd.allows(SourceLiteral):
+			l.warnIgnored(fd, SourceLiteral, fmt.Sprintf("value %q", value))
+			continue
+		}
+
+		if err := l.setFieldData(fd, value); err != nil {
+			return fmt.Errorf("incorrect literal of field %q: %w", name, err)
+		}
+		l.setSource(fd, SourceLiteral, value)
+		l.touch(fd)
+	}
+	return nil
+}
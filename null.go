@@ -0,0 +1,86 @@
+package aconfig
+
+import "reflect"
+
+// isNullValue reports whether a file value clears a field of the type:
+// null clears pointers, slices, maps and interfaces, the token from WithClearToken clears any field.
+// Null for other types is left to the decoders.
+func (l *Loader) isNullValue(typ reflect.Type, value interface{}) bool {
+	if value == nil {
+		switch typ.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+			return true
+		default:
+			return false
+		}
+	}
+	s, ok := value.(string)
+	return ok && l.config.ClearToken != "" && s == l.config.ClearToken
+}
+
+// takeNullValues removes from raw the values clearing the fields and returns such fields
+// with their raw values. Fields of a nested struct set to a clearing value are cleared too.
+func (l *Loader) takeNullValues(raw map[string]interface{}, ext string) map[*fieldData]interface{} {
+	type nullKey struct {
+		container map[string]interface{}
+		key       string
+	}
+
+	var keys []nullKey
+	values := map[*fieldData]interface{}{}
+	for _, fd := range l.fields {
+		container, key, ok := l.lookupNullPath(raw, fd, ext)
+		if !ok {
+			continue
+		}
+		values[fd] = container[key]
+		keys = append(keys, nullKey{container: container, key: key})
+	}
+
+	// keys are shared by the fields of a nested struct, so they're removed after the lookup
+	for _, k := range keys {
+		delete(k.container, k.key)
+	}
+	return values
+}
+
+// lookupNullPath finds a map containing a key of the field path with a value clearing the field or its parent.
+func (l *Loader) lookupNullPath(raw map[string]interface{}, fd *fieldData, ext string) (map[string]interface{}, string, bool) {
+	var fields []*fieldData
+	for f := fd; f != nil; f = f.parent {
+		fields = append([]*fieldData{f}, fields...)
+	}
+
+	path := filePath(fd, ext)
+	for i, key := range path {
+		realKey, ok := findFileKey(raw, key, foldsKeys(ext))
+		if !ok {
+			return nil, "", false
+		}
+		if l.isNullValue(fields[i].field.Type, raw[realKey]) {
+			return raw, realKey, true
+		}
+		if i == len(path)-1 {
+			return nil, "", false
+		}
+		if raw, ok = raw[realKey].(map[string]interface{}); !ok {
+			return nil, "", false
+		}
+	}
+	return nil, "", false
+}
+
+// clearValues clears the fields returned by takeNullValues.
+func (l *Loader) clearValues(values map[*fieldData]interface{}) {
+	for _, fd := range l.fields {
+		if value, ok := values[fd]; ok {
+			l.clearField(fd, value)
+		}
+	}
+}
+
+// clearField sets the field to zero value, pointers become nil.
+func (l *Loader) clearField(fd *fieldData, value interface{}) {
+	fd.value.Set(reflect.Zero(fd.value.Type()))
+	l.setSource(fd, SourceFile, rawString(value))
+}
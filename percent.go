@@ -0,0 +1,35 @@
+package aconfig
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+func isPercent(field *fieldData) bool {
+	return field.field.Tag.Get(percentTag) == "true"
+}
+
+// setPercent sets a float field from a percentage like `75%` (gives 0.75) or a ratio like `0.75`.
+func setPercent(field *fieldData, value string) error {
+	s := strings.TrimSpace(value)
+	num, isPercent := strings.TrimSuffix(s, "%"), strings.HasSuffix(s, "%")
+
+	val, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || math.IsInf(val, 0) || math.IsNaN(val) {
+		return fmt.Errorf("cannot parse %q as percent, want a value like 75%% or 0.75", value)
+	}
+
+	if isPercent {
+		if val < 0 || val > 100 {
+			return fmt.Errorf("percent %q must be from 0%% to 100%%", value)
+		}
+		val /= 100
+	} else if val < 0 || val > 1 {
+		return fmt.Errorf("ratio %q must be from 0 to 1", value)
+	}
+
+	field.value.SetFloat(val)
+	return nil
+}
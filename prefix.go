@@ -0,0 +1,93 @@
+package aconfig
+
+import (
+	"reflect"
+	"strings"
+)
+
+// LoadPrefix configuration only into the fields with the given name prefix, like `Database`
+// for `Database.Host` and `Database.Port`. Other fields aren't changed.
+func (l *Loader) LoadPrefix(into interface{}, prefix string) error {
+	l.prefix = strings.TrimSuffix(prefix, ".")
+	defer func() { l.prefix = "" }()
+
+	return l.Load(into)
+}
+
+// scopeFields returns the fields matching the prefix of LoadPrefix.
+func (l *Loader) scopeFields(fields []*fieldData) []*fieldData {
+	if l.prefix == "" {
+		return fields
+	}
+
+	scoped := make([]*fieldData, 0, len(fields))
+	for _, fd := range fields {
+		if fd.name == l.prefix || strings.HasPrefix(fd.name, l.prefix+".") {
+			scoped = append(scoped, fd)
+		}
+	}
+	return scoped
+}
+
+// loadIntoTemp runs fn for a new value of the target type and returns its fields by name.
+// This is used by LoadPrefix for the stages which set the whole struct at once.
+func (l *Loader) loadIntoTemp(fn func(tmp interface{}) error) (map[string]*fieldData, error) {
+	fields, lazy, impls, prefix := l.fields, l.lazy, l.impls, l.prefix
+	defer func() { l.fields, l.lazy, l.impls, l.prefix = fields, lazy, impls, prefix }()
+
+	// fields of the temporary value are reported by the caller, see OnFieldSet
+	onFieldSet := l.config.OnFieldSet
+	l.config.OnFieldSet = nil
+	defer func() { l.config.OnFieldSet = onFieldSet }()
+
+	typ := reflect.TypeOf(l.dst)
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	tmp := reflect.New(typ).Interface()
+	l.fields = l.getFields(tmp)
+	l.prefix = ""
+
+	if err := fn(tmp); err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string]*fieldData, len(l.fields))
+	for _, fd := range l.fields {
+		byName[fd.name] = fd
+	}
+	return byName, nil
+}
+
+// decodeFileScoped decodes the file into a new value and copies only the scoped fields.
+func (l *Loader) decodeFileScoped(data []byte, ext string) error {
+	tmpFields, err := l.loadIntoTemp(func(tmp interface{}) error {
+		return l.decodeFile(data, ext, tmp)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, fd := range l.fields {
+		if tmp, ok := tmpFields[fd.name]; ok && tmp.source == SourceFile {
+			fd.value.Set(tmp.value)
+			l.setSource(fd, SourceFile, valueString(tmp.value))
+			l.touch(fd)
+		}
+	}
+	return nil
+}
+
+// callDefaultersScoped calls Defaulter methods for a new value and copies only the scoped fields.
+func (l *Loader) callDefaultersScoped() {
+	tmpFields, _ := l.loadIntoTemp(func(tmp interface{}) error {
+		callDefaulters(reflect.ValueOf(tmp))
+		return nil
+	})
+
+	for _, fd := range l.fields {
+		if tmp, ok := tmpFields[fd.name]; ok && !tmp.value.IsZero() {
+			fd.value.Set(tmp.value)
+		}
+	}
+}
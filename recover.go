@@ -0,0 +1,54 @@
+package aconfig
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// recoverLoad returns a reflect panic (like one on a malformed target) as an error,
+// other panics (like ones of SetDefaults or a hook) are bugs of the caller, so they're passed on.
+// Lazy pointers are released in both cases. It must be deferred directly: `defer l.recoverLoad(&err)`.
+func (l *Loader) recoverLoad(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	l.releaseLazy()
+	if !isReflectPanic(r) {
+		panic(r)
+	}
+	*err = panicError("aconfig: cannot load config", r)
+}
+
+// recoverFieldPanic returns a reflect panic with the name of the field which is set as an error,
+// other panics are passed on.
+func recoverFieldPanic(err *error, field *fieldData) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if !isReflectPanic(r) {
+		panic(r)
+	}
+	*err = panicError(fmt.Sprintf("cannot set field %q", field.name), r)
+}
+
+// isReflectPanic reports whether the panic comes from reflect, like setting an unaddressable value.
+func isReflectPanic(r interface{}) bool {
+	switch r := r.(type) {
+	case *reflect.ValueError:
+		return true
+	case string:
+		return strings.HasPrefix(r, "reflect")
+	default:
+		return false
+	}
+}
+
+func panicError(msg string, r interface{}) error {
+	if err, ok := r.(error); ok {
+		return fmt.Errorf("%s: %w", msg, err)
+	}
+	return fmt.Errorf("%s: %v", msg, r)
+}
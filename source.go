@@ -0,0 +1,180 @@
+package aconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Source of a field value.
+type Source int
+
+// SourceUnset is returned for the fields that weren't set by any source.
+const SourceUnset Source = 0
+
+// Sources of field values.
+const (
+	SourceDefault Source = 1 << iota
+	SourceFile
+	SourceEnv
+	SourceFlag
+	SourceKV
+	SourceLiteral
+)
+
+// sourceNames are names of the sources in `source` tag and String.
+var sourceNames = []struct {
+	src  Source
+	name string
+}{
+	{SourceDefault, "default"},
+	{SourceFile, "file"},
+	{SourceEnv, "env"},
+	{SourceFlag, "flag"},
+	{SourceKV, "kv"},
+	{SourceLiteral, "literal"},
+}
+
+// String returns a name of the source, names of combined sources are separated by comma.
+func (s Source) String() string {
+	if s == SourceUnset {
+		return "unset"
+	}
+
+	var names []string
+	rest := s
+	for _, sn := range sourceNames {
+		if rest&sn.src != 0 {
+			names = append(names, sn.name)
+			rest &^= sn.src
+		}
+	}
+	if rest != 0 {
+		return fmt.Sprintf("Source(%d)", int(s))
+	}
+	return strings.Join(names, ",")
+}
+
+// skips returns Skip* options of the config by the sources they skip.
+func (c *loaderConfig) skips() map[Source]*bool {
+	return map[Source]*bool{
+		SourceDefault: &c.SkipDefaults,
+		SourceFile:    &c.SkipFile,
+		SourceEnv:     &c.SkipEnv,
+		SourceFlag:    &c.SkipFlag,
+		SourceKV:      &c.SkipKV,
+	}
+}
+
+// sources returns the sources which aren't skipped by Skip* options.
+func (c *loaderConfig) sources() Source {
+	var s Source
+	for src, skip := range c.skips() {
+		if !*skip {
+			s |= src
+		}
+	}
+	return s
+}
+
+// setSources sets Skip* options to skip all the sources except the given ones.
+func (c *loaderConfig) setSources(s Source) {
+	for src, skip := range c.skips() {
+		*skip = s&src == 0
+	}
+}
+
+// parseSources returns sources from `source:"file,flag"` tag, SourceUnset means any source.
+func parseSources(tag string) (Source, error) {
+	var s Source
+	for _, name := range splitTagList(tag) {
+		src := SourceUnset
+		for _, sn := range sourceNames {
+			if strings.EqualFold(name, sn.name) {
+				src = sn.src
+			}
+		}
+		if src == SourceUnset {
+			return SourceUnset, fmt.Errorf("unknown source %q", name)
+		}
+		s |= src
+	}
+	return s, nil
+}
+
+// checkSourceTags returns an error for a `source` tag with an unknown source.
+func (l *Loader) checkSourceTags() error {
+	for _, fd := range l.fields {
+		if _, err := parseSources(fd.Tag(sourceTag)); err != nil {
+			return fmt.Errorf("incorrect %s tag of field %q: %w", sourceTag, fd.name, err)
+		}
+	}
+	return nil
+}
+
+// allows reports whether the source can set the field, see `source` tag.
+func (f *fieldData) allows(src Source) bool {
+	return f.sources == SourceUnset || f.sources&src != 0
+}
+
+// warnIgnored logs a warning for a value of the source which cannot set the field.
+func (l *Loader) warnIgnored(fd *fieldData, src Source, from string) {
+	l.warnf("aconfig: %s %s is ignored, field %q can be set only from %s", src, from, fd.name, fd.sources)
+}
+
+// dropIgnoredValues removes from raw values of the fields which cannot be set from a file.
+func (l *Loader) dropIgnoredValues(raw map[string]interface{}, ext string) bool {
+	dropped := false
+	for _, fd := range l.fields {
+		if fd.allows(SourceFile) {
+			continue
+		}
+		if container, key, ok := lookupFileKey(raw, fd, ext); ok {
+			delete(container, key)
+			l.warnIgnored(fd, SourceFile, fmt.Sprintf("key %q", key))
+			dropped = true
+		}
+	}
+	return dropped
+}
+
+// SourceOf returns the source which set the field during the last load.
+// Field name is a dotted Go name like `HTTP.Port`, SourceUnset is returned for unknown fields.
+func (l *Loader) SourceOf(fieldName string) Source {
+	for _, fd := range l.fields {
+		if fd.name == fieldName {
+			return fd.source
+		}
+	}
+	return SourceUnset
+}
+
+// setSource marks the field as set by the source and calls OnFieldSet hook.
+func (l *Loader) setSource(fd *fieldData, src Source, rawValue string) {
+	fd.source = src
+	if l.config.OnFieldSet != nil {
+		l.config.OnFieldSet(fd.name, src.String(), rawValue)
+	}
+}
+
+// markFileSources marks the fields present in the decoded file.
+// Lazy pointers are touched too, keys of embedded pointers aren't present in the file.
+func (l *Loader) markFileSources(raw map[string]interface{}, ext string) {
+	for _, fd := range l.fields {
+		if m, key, ok := lookupFileKey(raw, fd, ext); ok {
+			l.setSource(fd, SourceFile, rawString(m[key]))
+			l.touch(fd)
+		}
+	}
+}
+
+// rawString returns a file value as it would be passed by env or flag.
+func rawString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	if data, err := json.Marshal(normalizeRaw(value)); err == nil {
+		return string(data)
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,150 @@
+package aconfig
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// fileKeyFormats are the formats which names are stored in fieldData.fileKeys.
+var fileKeyFormats = []string{".json", ".yaml", ".toml"}
+
+// fileKeysOf returns names of the field in the files of every format.
+func fileKeysOf(field reflect.StructField) map[string]string {
+	keys := make(map[string]string, len(fileKeyFormats))
+	for _, ext := range fileKeyFormats {
+		keys[ext] = fileKeyName(field, ext)
+	}
+	return keys
+}
+
+// fileKey returns a name of the field in a file of the given format.
+func (f *fieldData) fileKey(ext string) string {
+	if ext == ".yml" {
+		ext = ".yaml"
+	}
+	if key, ok := f.fileKeys[ext]; ok {
+		return key
+	}
+	return fileKeyName(f.field, ext)
+}
+
+// keyNode is a node of the file keys known by the loader, see checkUnknownFileKeys.
+type keyNode struct {
+	children map[string]*keyNode
+	// leaf is set for the fields and implementations, keys inside them aren't checked
+	leaf bool
+	// remain is set for the structs with `aconfig:",remain"` field, any key is accepted
+	remain bool
+}
+
+func (n *keyNode) child(key string) *keyNode {
+	if n.children == nil {
+		n.children = map[string]*keyNode{}
+	}
+	c, ok := n.children[key]
+	if !ok {
+		c = &keyNode{}
+		n.children[key] = c
+	}
+	return c
+}
+
+// knownFileKeys returns a tree of the keys matching the fields in a file of the given format.
+func (l *Loader) knownFileKeys(ext string) *keyNode {
+	root := &keyNode{remain: hasRemainField(reflect.TypeOf(l.dst))}
+
+	add := func(fd *fieldData) *keyNode {
+		node := root
+		for _, key := range filePath(fd, ext) {
+			node = node.child(key)
+		}
+		return node
+	}
+
+	for _, fd := range l.fields {
+		add(fd).leaf = true
+		for p := fd.parent; p != nil; p = p.parent {
+			if hasRemainField(p.field.Type) {
+				add(p).remain = true
+			}
+		}
+	}
+	for _, fd := range l.impls {
+		add(fd).leaf = true
+	}
+	return root
+}
+
+// checkUnknownFileKeys returns an error for the file keys which don't match any field, see FailOnUnknownFileKeys.
+// Keys are matched like the decoder does, ignoring case for JSON and TOML (and for any format with CaseInsensitiveKeys).
+func (l *Loader) checkUnknownFileKeys(raw map[string]interface{}, ext string) error {
+	fold := l.config.CaseInsensitiveKeys || foldsKeys(ext)
+
+	var unknown []string
+	collectUnknownKeys(l.knownFileKeys(ext), raw, "", fold, &unknown)
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	sort.Strings(unknown)
+	for i, key := range unknown {
+		unknown[i] = fmt.Sprintf("%q", key)
+	}
+	return fmt.Errorf("unknown keys %s", strings.Join(unknown, ", "))
+}
+
+func collectUnknownKeys(node *keyNode, raw map[string]interface{}, prefix string, fold bool, unknown *[]string) {
+	for key, val := range raw {
+		child := lookupKeyNode(node, key, fold)
+		switch {
+		case child == nil && node.remain:
+		case child == nil:
+			*unknown = append(*unknown, prefix+key)
+		case child.leaf:
+		default:
+			if sub, ok := val.(map[string]interface{}); ok {
+				collectUnknownKeys(child, sub, prefix+key+".", fold, unknown)
+			}
+		}
+	}
+}
+
+// lookupKeyNode finds a node of the key, dotted keys like `http.port` are matched too (see CaseInsensitiveKeys).
+func lookupKeyNode(node *keyNode, key string, fold bool) *keyNode {
+	if c, ok := node.children[key]; ok {
+		return c
+	}
+	for k, c := range node.children {
+		if fold && strings.EqualFold(k, key) {
+			return c
+		}
+	}
+	if !strings.Contains(key, ".") {
+		return nil
+	}
+	for _, part := range strings.Split(key, ".") {
+		if node = lookupKeyNode(node, part, fold); node == nil || node.leaf {
+			return node
+		}
+	}
+	return node
+}
+
+// hasRemainField reports whether the struct (or an embedded one) has a field with `aconfig:",remain"` tag.
+func hasRemainField(typ reflect.Type) bool {
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if isRemainField(field) || field.Anonymous && hasRemainField(field.Type) {
+			return true
+		}
+	}
+	return false
+}
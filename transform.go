@@ -0,0 +1,79 @@
+package aconfig
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// transforms are applied to string values by `transform:"lower,trim"` tag.
+var transforms = map[string]func(string) string{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+	"title": titleCase,
+}
+
+// applyTransforms runs transforms from `transform` tag on the loaded string values,
+// strings in slices and map values are transformed too.
+func (l *Loader) applyTransforms() error {
+	for _, fd := range l.fields {
+		names := splitTagList(fd.transform)
+		if len(names) == 0 {
+			continue
+		}
+
+		fns := make([]func(string) string, 0, len(names))
+		for _, name := range names {
+			fn, ok := transforms[name]
+			if !ok {
+				return fmt.Errorf("unknown transform %q of field %q", name, fd.name)
+			}
+			fns = append(fns, fn)
+		}
+		transformValue(fd.value, fns)
+	}
+	return nil
+}
+
+func transformValue(value reflect.Value, fns []func(string) string) {
+	switch value.Kind() {
+	case reflect.String:
+		s := value.String()
+		for _, fn := range fns {
+			s = fn(s)
+		}
+		value.SetString(s)
+
+	case reflect.Ptr:
+		if !value.IsNil() {
+			transformValue(value.Elem(), fns)
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			transformValue(value.Index(i), fns)
+		}
+
+	case reflect.Map:
+		iter := value.MapRange()
+		for iter.Next() {
+			elem := reflect.New(value.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			transformValue(elem, fns)
+			value.SetMapIndex(iter.Key(), elem)
+		}
+	}
+}
+
+// titleCase makes the first letter of every word upper case.
+func titleCase(s string) string {
+	runes := []rune(s)
+	for i, r := range runes {
+		if i == 0 || !unicode.IsLetter(runes[i-1]) && !unicode.IsDigit(runes[i-1]) {
+			runes[i] = unicode.ToUpper(r)
+		}
+	}
+	return string(runes)
+}
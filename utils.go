@@ -0,0 +1,121 @@
+package aconfig
+
+import (
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// splitNameWords splits every part of a dotted name into words joined with sep.
+func splitNameWords(name, sep string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = strings.Join(splitWords(part), sep)
+	}
+	return strings.Join(parts, ".")
+}
+
+// splitWords splits a camel case name into words keeping acronyms together:
+// `HTTPPort` gives `HTTP`, `Port` and `MaxConns` gives `Max`, `Conns`.
+func splitWords(name string) []string {
+	runes := []rune(name)
+
+	var words []string
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		prev, curr := runes[i-1], runes[i]
+		switch {
+		case (unicode.IsLower(prev) || unicode.IsDigit(prev)) && unicode.IsUpper(curr):
+		case unicode.IsUpper(prev) && unicode.IsUpper(curr) && i+1 < len(runes) && unicode.IsLower(runes[i+1]):
+		default:
+			continue
+		}
+		words = append(words, string(runes[start:i]))
+		start = i
+	}
+	if start < len(runes) {
+		words = append(words, string(runes[start:]))
+	}
+	return words
+}
+
+// closestName returns a name with the smallest edit distance to the given name.
+// Empty string is returned when nothing is close enough.
+func closestName(name string, names []string) string {
+	best, bestDist := "", len(name)/2+1
+	for _, candidate := range names {
+		if dist := levenshtein(name, candidate); dist < bestDist {
+			best, bestDist = candidate, dist
+		}
+	}
+	return best
+}
+
+// levenshtein returns an edit distance between two strings.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitTagList splits a comma separated tag value skipping empty items.
+func splitTagList(tag string) []string {
+	var items []string
+	for _, item := range strings.Split(tag, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func containsString(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func isIntegerKind(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Uintptr
+}
+
+func isFloatKind(kind reflect.Kind) bool {
+	return kind == reflect.Float32 || kind == reflect.Float64
+}
@@ -0,0 +1,152 @@
+package aconfig
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	requiredTag = "required"
+	minTag      = "min"
+	maxTag      = "max"
+	oneOfTag    = "oneof"
+	regexTag    = "regex"
+)
+
+// Validator can be implemented by a configuration structure
+// to check itself after all the fields are loaded.
+type Validator interface {
+	Validate() error
+}
+
+// Validate runs validations against the values of the last Load call.
+// Sources are not read again, so values can be changed before validation.
+// Load calls it at the end, unless SkipValidation is set.
+//
+// Supported tags are `required:"true"`, `min:"1"`, `max:"10"` (value for numbers,
+// length for strings, slices and maps), `oneof:"a,b,c"` (case-insensitive with IgnoreCase)
+// and `regex:"^[a-z]+$"` for strings. Zero values are checked only by `required`.
+// After the tags, Validate method of the configuration is called if it implements Validator.
+func (l *Loader) Validate() error {
+	l.assertBuilt()
+
+	if err := l.validate(); err != nil {
+		return fmt.Errorf("aconfig: invalid config: %w", err)
+	}
+	return nil
+}
+
+func (l *Loader) validate() error {
+	for _, fd := range l.fields {
+		if fd.isReleased() {
+			continue
+		}
+		if err := validateField(fd, l.config.IgnoreCase); err != nil {
+			return err
+		}
+	}
+
+	if v, ok := l.dst.(Validator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+func validateField(fd *fieldData, ignoreCase bool) error {
+	value := fd.value
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+
+	if fd.Tag(requiredTag) == "true" && value.IsZero() {
+		return fmt.Errorf("field %q is required", fd.name)
+	}
+
+	// nothing to check for unset pointers,
+	// zero value of the field which isn't required is allowed
+	if value.Kind() == reflect.Ptr || value.IsZero() {
+		return nil
+	}
+
+	if err := validateLimit(fd, value, minTag); err != nil {
+		return err
+	}
+	if err := validateLimit(fd, value, maxTag); err != nil {
+		return err
+	}
+	if err := validateOneOf(fd, value, ignoreCase); err != nil {
+		return err
+	}
+	return validateRegex(fd, value)
+}
+
+func validateLimit(fd *fieldData, value reflect.Value, tag string) error {
+	limitValue := fd.Tag(tag)
+	if limitValue == "" {
+		return nil
+	}
+
+	limit, err := strconv.ParseFloat(limitValue, 64)
+	if err != nil {
+		return fmt.Errorf("incorrect %s tag %q of field %q: %w", tag, limitValue, fd.name, err)
+	}
+
+	var got float64
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		got = float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		got = float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		got = value.Float()
+	case reflect.String, reflect.Slice, reflect.Map:
+		got = float64(value.Len())
+	default:
+		return fmt.Errorf("%s tag isn't supported for field %q of kind %q", tag, fd.name, value.Kind())
+	}
+
+	switch {
+	case tag == minTag && got < limit:
+		return fmt.Errorf("field %q must be at least %v, got %v", fd.name, limitValue, got)
+	case tag == maxTag && got > limit:
+		return fmt.Errorf("field %q must be at most %v, got %v", fd.name, limitValue, got)
+	default:
+		return nil
+	}
+}
+
+func validateOneOf(fd *fieldData, value reflect.Value, ignoreCase bool) error {
+	oneOf := fd.Tag(oneOfTag)
+	if oneOf == "" {
+		return nil
+	}
+
+	got := fmt.Sprint(value.Interface())
+	if _, ok := matchOneOf(oneOf, got, ignoreCase); ok {
+		return nil
+	}
+	options := strings.Split(oneOf, ",")
+	return fmt.Errorf("field %q must be one of %v, got %q", fd.name, options, got)
+}
+
+func validateRegex(fd *fieldData, value reflect.Value) error {
+	pattern := fd.Tag(regexTag)
+	if pattern == "" {
+		return nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("incorrect %s tag %q of field %q: %w", regexTag, pattern, fd.name, err)
+	}
+	if value.Kind() != reflect.String {
+		return fmt.Errorf("%s tag isn't supported for field %q of kind %q", regexTag, fd.name, value.Kind())
+	}
+	if !re.MatchString(value.String()) {
+		return fmt.Errorf("field %q must match %q, got %q", fd.name, pattern, value.String())
+	}
+	return nil
+}